@@ -0,0 +1,463 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command atlaspack packs a directory of PNGs into a single power of two
+// texture, and writes the JSON array spritesheet twodee reads alongside it.
+//
+//	atlaspack [-out spritesheet] [-padding 2] [-extrude 0] [-trim] [-rotate] dir
+//
+// Frames are named after their files, without the .png extension.  Trimming
+// drops fully transparent edges and records where the frame sat in its
+// source.  Extrusion repeats each frame's edge pixels to stop neighbours
+// bleeding in when filtering.  Rotation lets frames be packed a quarter turn
+// clockwise; twodee's own parser cannot read those, but the basic example's
+// can.  The same inputs and flags always produce the same bytes.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Sprite is a frame being packed.
+type Sprite struct {
+	Name string
+
+	// Image holds the frame's pixels after trimming, unrotated.
+	Image *image.NRGBA
+
+	// Trim is the part of the source image kept, and Source the size of the
+	// source image.
+	Trim   image.Rectangle
+	Source image.Point
+
+	// Where the frame was packed, and whether it was turned to fit.
+	X, Y    int
+	Rotated bool
+}
+
+// Size returns the frame's size in the atlas.
+func (s *Sprite) Size() (w, h int) {
+	w, h = s.Image.Bounds().Dx(), s.Image.Bounds().Dy()
+	if s.Rotated {
+		w, h = h, w
+	}
+	return
+}
+
+// LoadSprites reads every PNG in dir, sorted by name.
+func LoadSprites(dir string, trim bool) (sprites []*Sprite, err error) {
+	var paths []string
+	if paths, err = filepath.Glob(filepath.Join(dir, "*.png")); err != nil {
+		return
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		var sprite *Sprite
+		if sprite, err = LoadSprite(path, trim); err != nil {
+			err = fmt.Errorf("Could not load %v: %v", path, err)
+			return
+		}
+		sprites = append(sprites, sprite)
+	}
+	return
+}
+
+func LoadSprite(path string, trim bool) (sprite *Sprite, err error) {
+	var (
+		file *os.File
+		img  image.Image
+	)
+	if file, err = os.Open(path); err != nil {
+		return
+	}
+	defer file.Close()
+	if img, err = png.Decode(file); err != nil {
+		return
+	}
+	var (
+		bounds = img.Bounds()
+		kept   = image.Rect(0, 0, bounds.Dx(), bounds.Dy())
+	)
+	if trim {
+		kept = Opaque(img)
+	}
+	sprite = &Sprite{
+		Name:   strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		Image:  image.NewNRGBA(image.Rect(0, 0, kept.Dx(), kept.Dy())),
+		Trim:   kept,
+		Source: image.Pt(bounds.Dx(), bounds.Dy()),
+	}
+	draw.Draw(sprite.Image, sprite.Image.Bounds(), img, bounds.Min.Add(kept.Min), draw.Src)
+	return
+}
+
+// Opaque returns the smallest rectangle, relative to the image's top left,
+// holding every pixel which is not fully transparent.  A fully transparent
+// image keeps its top left pixel, since frames cannot be empty.
+func Opaque(img image.Image) (rect image.Rectangle) {
+	var bounds = img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a == 0 {
+				continue
+			}
+			var pixel = image.Rect(x, y, x+1, y+1).Sub(bounds.Min)
+			if rect.Empty() {
+				rect = pixel
+			} else {
+				rect = rect.Union(pixel)
+			}
+		}
+	}
+	if rect.Empty() {
+		rect = image.Rect(0, 0, 1, 1)
+	}
+	return
+}
+
+// Packer places rectangles with the MaxRects algorithm, choosing for each
+// the free space it fits most snugly along its shorter side.
+type Packer struct {
+	free []image.Rectangle
+}
+
+func NewPacker(area image.Rectangle) *Packer {
+	return &Packer{free: []image.Rectangle{area}}
+}
+
+// Place finds room for a w by h rectangle, or an h by w one if rotate is
+// set, reporting false if there is none.  Ties go to the unrotated fit and
+// then to the earliest free space, so packing is repeatable.
+func (p *Packer) Place(w, h int, rotate bool) (pt image.Point, rotated, ok bool) {
+	var bestShort, bestLong int
+	try := func(w, h int, turned bool) {
+		for _, free := range p.free {
+			if w > free.Dx() || h > free.Dy() {
+				continue
+			}
+			var (
+				short = minInt(free.Dx()-w, free.Dy()-h)
+				long  = maxInt(free.Dx()-w, free.Dy()-h)
+			)
+			if !ok || short < bestShort || (short == bestShort && long < bestLong) {
+				pt, rotated, ok = free.Min, turned, true
+				bestShort, bestLong = short, long
+			}
+		}
+	}
+	try(w, h, false)
+	if rotate && w != h {
+		try(h, w, true)
+	}
+	if ok {
+		if rotated {
+			w, h = h, w
+		}
+		p.use(image.Rectangle{pt, pt.Add(image.Pt(w, h))})
+	}
+	return
+}
+
+// use splits every free space overlapping used into the parts left around
+// it, then drops spaces lying inside others.
+func (p *Packer) use(used image.Rectangle) {
+	var free []image.Rectangle
+	for _, f := range p.free {
+		if !f.Overlaps(used) {
+			free = append(free, f)
+			continue
+		}
+		if used.Min.X > f.Min.X {
+			free = append(free, image.Rect(f.Min.X, f.Min.Y, used.Min.X, f.Max.Y))
+		}
+		if used.Max.X < f.Max.X {
+			free = append(free, image.Rect(used.Max.X, f.Min.Y, f.Max.X, f.Max.Y))
+		}
+		if used.Min.Y > f.Min.Y {
+			free = append(free, image.Rect(f.Min.X, f.Min.Y, f.Max.X, used.Min.Y))
+		}
+		if used.Max.Y < f.Max.Y {
+			free = append(free, image.Rect(f.Min.X, used.Max.Y, f.Max.X, f.Max.Y))
+		}
+	}
+	p.free = p.free[:0]
+	for i, a := range free {
+		var contained = false
+		for j, b := range free {
+			// Of two equal spaces, keep the first.
+			if i != j && a.In(b) && (a != b || j < i) {
+				contained = true
+				break
+			}
+		}
+		if !contained {
+			p.free = append(p.free, a)
+		}
+	}
+}
+
+// Pack places every sprite in the smallest power of two texture, no larger
+// than maxSize on a side, that fits them all.  Each frame is surrounded by
+// extrude pixels of its own edges, and kept padding pixels from its
+// neighbours and the texture's edges.
+func Pack(sprites []*Sprite, padding, extrude int, rotate bool, maxSize int) (size image.Point, err error) {
+	// Big frames are hardest to fit, so they go first.
+	var order = make([]*Sprite, len(sprites))
+	copy(order, sprites)
+	sort.SliceStable(order, func(i, j int) bool {
+		var (
+			a, b   = order[i].Image.Bounds(), order[j].Image.Bounds()
+			as, bs = maxInt(a.Dx(), a.Dy()), maxInt(b.Dx(), b.Dy())
+		)
+		if as != bs {
+			return as > bs
+		}
+		return a.Dx()*a.Dy() > b.Dx()*b.Dy()
+	})
+	for _, size = range textureSizes(maxSize) {
+		if packInto(order, size, padding, extrude, rotate) {
+			return
+		}
+	}
+	err = fmt.Errorf("Frames do not fit in %vx%v", maxSize, maxSize)
+	return
+}
+
+// textureSizes lists power of two sizes up to maxSize, smallest first, with
+// squarer textures before longer ones of the same area.
+func textureSizes(maxSize int) (sizes []image.Point) {
+	for w := 1; w <= maxSize; w *= 2 {
+		for h := 1; h <= maxSize; h *= 2 {
+			sizes = append(sizes, image.Pt(w, h))
+		}
+	}
+	sort.Slice(sizes, func(i, j int) bool {
+		var a, b = sizes[i], sizes[j]
+		if a.X*a.Y != b.X*b.Y {
+			return a.X*a.Y < b.X*b.Y
+		}
+		if maxInt(a.X, a.Y) != maxInt(b.X, b.Y) {
+			return maxInt(a.X, a.Y) < maxInt(b.X, b.Y)
+		}
+		return a.X > b.X
+	})
+	return
+}
+
+// packInto tries to place every sprite in a texture of the given size.
+func packInto(sprites []*Sprite, size image.Point, padding, extrude int, rotate bool) bool {
+	// Each frame takes its padding to the right and below, and the texture
+	// is inset by the padding on the top and left.
+	var packer = NewPacker(image.Rect(padding, padding, size.X, size.Y))
+	for _, s := range sprites {
+		var (
+			bounds          = s.Image.Bounds()
+			pt, rotated, ok = packer.Place(bounds.Dx()+2*extrude+padding, bounds.Dy()+2*extrude+padding, rotate)
+		)
+		if !ok {
+			return false
+		}
+		s.X, s.Y, s.Rotated = pt.X+extrude, pt.Y+extrude, rotated
+	}
+	return true
+}
+
+// Render draws packed sprites into a texture of the given size.
+func Render(sprites []*Sprite, size image.Point, extrude int) *image.NRGBA {
+	var atlas = image.NewNRGBA(image.Rect(0, 0, size.X, size.Y))
+	for _, s := range sprites {
+		var (
+			src  = s.Image
+			w, h = s.Size()
+		)
+		if s.Rotated {
+			src = rotateClockwise(src)
+		}
+		for y := -extrude; y < h+extrude; y++ {
+			for x := -extrude; x < w+extrude; x++ {
+				atlas.SetNRGBA(s.X+x, s.Y+y, src.NRGBAAt(clamp(x, 0, w-1), clamp(y, 0, h-1)))
+			}
+		}
+	}
+	return atlas
+}
+
+// rotateClockwise turns an image a quarter turn clockwise, the way
+// TexturePacker stores rotated frames.
+func rotateClockwise(img *image.NRGBA) *image.NRGBA {
+	var (
+		w, h    = img.Bounds().Dx(), img.Bounds().Dy()
+		rotated = image.NewNRGBA(image.Rect(0, 0, h, w))
+	)
+	for y := 0; y < w; y++ {
+		for x := 0; x < h; x++ {
+			rotated.SetNRGBA(x, y, img.NRGBAAt(y, h-1-x))
+		}
+	}
+	return rotated
+}
+
+type sheetRect struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type sheetSize struct {
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type sheetPivot struct {
+	X float32 `json:"x"`
+	Y float32 `json:"y"`
+}
+
+type sheetFrame struct {
+	Filename         string     `json:"filename"`
+	Frame            sheetRect  `json:"frame"`
+	Rotated          bool       `json:"rotated"`
+	Trimmed          bool       `json:"trimmed"`
+	SpriteSourceSize sheetRect  `json:"spriteSourceSize"`
+	SourceSize       sheetSize  `json:"sourceSize"`
+	Pivot            sheetPivot `json:"pivot"`
+}
+
+type sheetMeta struct {
+	App    string    `json:"app"`
+	Image  string    `json:"image"`
+	Format string    `json:"format"`
+	Size   sheetSize `json:"size"`
+	Scale  string    `json:"scale"`
+}
+
+type sheet struct {
+	Frames []sheetFrame `json:"frames"`
+	Meta   sheetMeta    `json:"meta"`
+}
+
+// Sheet returns the TexturePacker JSON array description of packed sprites,
+// in name order.  Rotated frames give their size before rotation, as
+// TexturePacker's do.
+func Sheet(sprites []*Sprite, size image.Point, texture string) ([]byte, error) {
+	var doc = sheet{
+		Frames: make([]sheetFrame, len(sprites)),
+		Meta: sheetMeta{
+			App:    "atlaspack",
+			Image:  texture,
+			Format: "RGBA8888",
+			Size:   sheetSize{size.X, size.Y},
+			Scale:  "1",
+		},
+	}
+	for i, s := range sprites {
+		var bounds = s.Image.Bounds()
+		doc.Frames[i] = sheetFrame{
+			Filename:         s.Name,
+			Frame:            sheetRect{s.X, s.Y, bounds.Dx(), bounds.Dy()},
+			Rotated:          s.Rotated,
+			Trimmed:          s.Trim.Size() != s.Source,
+			SpriteSourceSize: sheetRect{s.Trim.Min.X, s.Trim.Min.Y, s.Trim.Dx(), s.Trim.Dy()},
+			SourceSize:       sheetSize{s.Source.X, s.Source.Y},
+			Pivot:            sheetPivot{0.5, 0.5},
+		}
+	}
+	return json.MarshalIndent(doc, "", "\t")
+}
+
+func clamp(v, lo, hi int) int {
+	return minInt(maxInt(v, lo), hi)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func main() {
+	var (
+		sprites []*Sprite
+		size    image.Point
+		data    []byte
+		file    *os.File
+		err     error
+		out     = flag.String("out", "spritesheet", "Path to write .png and .json to, without extension")
+		padding = flag.Int("padding", 2, "Pixels between frames and around the texture")
+		extrude = flag.Int("extrude", 0, "Pixels of each frame's edge to repeat around it")
+		trim    = flag.Bool("trim", true, "Drop transparent edges from frames")
+		rotate  = flag.Bool("rotate", false, "Allow frames to be turned a quarter turn to fit")
+		maxSize = flag.Int("max", 4096, "Largest texture side")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %v [flags] dir\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || *padding < 0 || *extrude < 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if sprites, err = LoadSprites(flag.Arg(0), *trim); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if len(sprites) == 0 {
+		fmt.Fprintf(os.Stderr, "No PNGs in %v\n", flag.Arg(0))
+		os.Exit(1)
+	}
+	if size, err = Pack(sprites, *padding, *extrude, *rotate, *maxSize); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if file, err = os.Create(*out + ".png"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err = png.Encode(file, Render(sprites, size, *extrude)); err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not write %v.png: %v\n", *out, err)
+		os.Exit(1)
+	}
+	if data, err = Sheet(sprites, size, filepath.Base(*out)+".png"); err == nil {
+		err = ioutil.WriteFile(*out+".json", append(data, '\n'), 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not write %v.json: %v\n", *out, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Packed %v frames into %vx%v\n", len(sprites), size.X, size.Y)
+}
@@ -0,0 +1,213 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+)
+
+// AnimationDirection is the order an animation plays its frames in.
+type AnimationDirection int
+
+const (
+	AnimationForward AnimationDirection = iota
+	AnimationReverse
+
+	// Forward then back again, without repeating the first or last frame.
+	AnimationPingPong
+
+	// Back from the last frame then forward again, like AnimationPingPong
+	// started from the other end.
+	AnimationPingPongReverse
+)
+
+// Animation is a run of spritesheet frames, each shown for its own duration.
+// Frames are indices into the sheet's Names.
+type Animation struct {
+	Name      string
+	Frames    []int
+	Durations []time.Duration
+	Direction AnimationDirection
+}
+
+// Animations are looked up by name, such as "walk" or "idle".
+type Animations map[string]*Animation
+
+// steps is how many frames are shown before the animation repeats.
+func (a *Animation) steps() int {
+	var n = len(a.Frames)
+	if (a.Direction == AnimationPingPong || a.Direction == AnimationPingPongReverse) && n > 1 {
+		return 2*n - 2
+	}
+	return n
+}
+
+// position returns which of Frames is shown at a step.
+func (a *Animation) position(step int) int {
+	var n = len(a.Frames)
+	switch a.Direction {
+	case AnimationReverse:
+		return n - 1 - step
+	case AnimationPingPong:
+		if step >= n {
+			return 2*n - 2 - step
+		}
+	case AnimationPingPongReverse:
+		if step >= n {
+			return step - n + 1
+		}
+		return n - 1 - step
+	}
+	return step
+}
+
+// Animator plays an animation, looping it until another is played unless
+// it is played once.
+type Animator struct {
+	Animation *Animation
+
+	// OnFrame is called with the position in Frames of each frame as it
+	// starts to show.
+	OnFrame func(animation *Animation, position int)
+
+	once    bool
+	done    bool
+	step    int
+	elapsed time.Duration
+}
+
+// Play switches to an animation, starting it from the beginning unless it is
+// already looping.
+func (a *Animator) Play(animation *Animation) {
+	if a.Animation == animation && !a.once {
+		return
+	}
+	a.start(animation, false)
+}
+
+// PlayOnce starts an animation from the beginning, stopping on its last
+// frame.
+func (a *Animator) PlayOnce(animation *Animation) {
+	a.start(animation, true)
+}
+
+func (a *Animator) start(animation *Animation, once bool) {
+	a.Animation = animation
+	a.once = once
+	a.done = false
+	a.step = 0
+	a.elapsed = 0
+	a.frameShown()
+}
+
+// Done reports whether an animation played once has finished.
+func (a *Animator) Done() bool {
+	return a.done
+}
+
+func (a *Animator) frameShown() {
+	if a.OnFrame != nil && a.Animation != nil && len(a.Animation.Frames) > 0 {
+		a.OnFrame(a.Animation, a.Animation.position(a.step))
+	}
+}
+
+func (a *Animator) Update(elapsed time.Duration) {
+	if a.Animation == nil || len(a.Animation.Frames) == 0 || a.done {
+		return
+	}
+	a.elapsed += elapsed
+	for {
+		var duration = a.Animation.Durations[a.Animation.position(a.step)]
+		if duration <= 0 || a.elapsed < duration {
+			return
+		}
+		a.elapsed -= duration
+		if a.once && a.step == a.Animation.steps()-1 {
+			a.done = true
+			a.elapsed = 0
+			return
+		}
+		a.step = (a.step + 1) % a.Animation.steps()
+		a.frameShown()
+	}
+}
+
+// Frame returns the index of the sheet frame being shown, or 0 if nothing is
+// playing.
+func (a *Animator) Frame() int {
+	if a.Animation == nil || len(a.Animation.Frames) == 0 {
+		return 0
+	}
+	return a.Animation.Frames[a.Animation.position(a.step)]
+}
+
+// AnimationPlayer plays named animations, with a duration for every frame,
+// instead of the list of frame indices a twodee.AnimatingEntity loops.  It
+// is the animation component of entities in a World.
+type AnimationPlayer struct {
+	Animations Animations
+
+	// Frames holds the sprite frame for each frame index the animations
+	// refer to.
+	Frames []FrameHandle
+
+	animator Animator
+}
+
+// Play loops the named animation, reporting false if there is no such
+// animation.
+func (p *AnimationPlayer) Play(name string) bool {
+	var animation, ok = p.Animations[name]
+	if ok {
+		p.animator.Play(animation)
+	}
+	return ok
+}
+
+// PlayOnce plays the named animation from the start, once.
+func (p *AnimationPlayer) PlayOnce(name string) bool {
+	var animation, ok = p.Animations[name]
+	if ok {
+		p.animator.PlayOnce(animation)
+	}
+	return ok
+}
+
+// Finished reports whether an animation played once has finished.
+func (p *AnimationPlayer) Finished() bool {
+	return p.animator.Done()
+}
+
+// OnFrame sets what is called as each frame of an animation starts to show.
+func (p *AnimationPlayer) OnFrame(callback func(animation *Animation, position int)) {
+	p.animator.OnFrame = callback
+}
+
+// Playing returns the name of the animation being played.
+func (p *AnimationPlayer) Playing() string {
+	if p.animator.Animation == nil {
+		return ""
+	}
+	return p.animator.Animation.Name
+}
+
+func (p *AnimationPlayer) Update(elapsed time.Duration) {
+	p.animator.Update(elapsed)
+}
+
+// Frame returns the index of the sheet frame to draw.
+func (p *AnimationPlayer) Frame() int {
+	return p.animator.Frame()
+}
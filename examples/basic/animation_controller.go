@@ -0,0 +1,177 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	twodee "../../libs/twodee"
+	"fmt"
+	"time"
+)
+
+// FootstepEvent is raised by walking animations as each foot lands.
+const FootstepEvent = "footstep"
+
+// AnimationEvent is sent through the GameEventHandler with the
+// AnimationFrame type when a frame with an event is shown.
+type AnimationEvent struct {
+	*twodee.BasicGameEvent
+	Entity twodee.Entity
+	State  string
+	Name   string
+}
+
+func NewAnimationEvent(entity twodee.Entity, state, name string) *AnimationEvent {
+	return &AnimationEvent{
+		BasicGameEvent: twodee.NewBasicGameEvent(AnimationFrame),
+		Entity:         entity,
+		State:          state,
+		Name:           name,
+	}
+}
+
+// AnimationState is a named state of an AnimationController, such as idle
+// or walk_left, which plays one of the entity's animations.
+type AnimationState struct {
+	Name      string
+	Animation string
+
+	// OneShot states play their animation once and then return to the
+	// state they were entered from.  Transitions wait until they finish.
+	OneShot bool
+
+	// Events names positions in the animation's frames which raise an event
+	// as they are shown, such as a footstep.
+	Events map[int]string
+}
+
+// AnimationTransition moves the controller from one state to another when
+// When holds for the entity's velocity, in world units per second.  An empty
+// From matches every state.
+type AnimationTransition struct {
+	From string
+	To   string
+	When func(velocity twodee.Point) bool
+}
+
+// AnimationController picks an entity's animation from named states.
+// States change by the first matching transition as the entity moves, or by
+// Trigger.  Frame events are passed to OnEvent.
+type AnimationController struct {
+	Entity      *EntityRef
+	States      map[string]*AnimationState
+	Transitions []AnimationTransition
+	OnEvent     func(state *AnimationState, event string)
+
+	current  *AnimationState
+	previous *AnimationState
+	last     twodee.Point
+	velocity twodee.Point
+}
+
+// NewAnimationController returns a controller for an entity which already
+// has an animation component.
+func NewAnimationController(entity *EntityRef) (c *AnimationController) {
+	c = &AnimationController{
+		Entity: entity,
+		States: map[string]*AnimationState{},
+		last:   entity.Pos(),
+	}
+	entity.Animation().OnFrame(c.onFrame)
+	return
+}
+
+func (c *AnimationController) AddState(state *AnimationState) {
+	c.States[state.Name] = state
+}
+
+func (c *AnimationController) AddTransition(from, to string, when func(velocity twodee.Point) bool) {
+	c.Transitions = append(c.Transitions, AnimationTransition{from, to, when})
+}
+
+// State returns the name of the current state.
+func (c *AnimationController) State() string {
+	if c.current == nil {
+		return ""
+	}
+	return c.current.Name
+}
+
+// Velocity returns how fast the entity moved over the last update.
+func (c *AnimationController) Velocity() twodee.Point {
+	return c.velocity
+}
+
+// Trigger enters a state straight away.  A one shot state entered from
+// another one shot state returns to where the first was entered from.
+func (c *AnimationController) Trigger(name string) (err error) {
+	var state, ok = c.States[name]
+	if !ok {
+		return fmt.Errorf("No animation state %v", name)
+	}
+	if state.OneShot {
+		if c.current != nil && !c.current.OneShot {
+			c.previous = c.current
+		}
+		c.current = state
+		if !c.Entity.Animation().PlayOnce(state.Animation) {
+			err = fmt.Errorf("State %v has no animation %v", name, state.Animation)
+		}
+		return
+	}
+	c.current = state
+	if !c.Entity.Animation().Play(state.Animation) {
+		err = fmt.Errorf("State %v has no animation %v", name, state.Animation)
+	}
+	return
+}
+
+// Update measures the entity's velocity and changes state to match.  Run it
+// after the systems which move the entity and advance its animation.
+func (c *AnimationController) Update(w *World, elapsed time.Duration) {
+	var (
+		pos = c.Entity.Pos()
+		dt  = float32(elapsed.Seconds())
+	)
+	if dt > 0 {
+		c.velocity = twodee.Pt((pos.X-c.last.X)/dt, (pos.Y-c.last.Y)/dt)
+	}
+	c.last = pos
+	if c.current != nil && c.current.OneShot {
+		if !c.Entity.Animation().Finished() {
+			return
+		}
+		c.current = nil
+		if c.previous != nil {
+			c.Trigger(c.previous.Name)
+		}
+	}
+	for _, t := range c.Transitions {
+		if (t.From == "" || t.From == c.State()) && t.To != c.State() && t.When(c.velocity) {
+			if err := c.Trigger(t.To); err != nil {
+				fmt.Printf("Problem changing animation: %v\n", err)
+			}
+			return
+		}
+	}
+}
+
+func (c *AnimationController) onFrame(animation *Animation, position int) {
+	if c.current == nil || c.OnEvent == nil || c.current.Animation != animation.Name {
+		return
+	}
+	if event, ok := c.current.Events[position]; ok {
+		c.OnEvent(c.current, event)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// DefaultAnimation plays every frame of an Aseprite sheet without tags.
+const DefaultAnimation = "default"
+
+type asepriteTag struct {
+	Name      string `json:"name"`
+	From      int    `json:"from"`
+	To        int    `json:"to"`
+	Direction string `json:"direction"`
+}
+
+type asepriteSheet struct {
+	Frames json.RawMessage `json:"frames"`
+	Meta   struct {
+		FrameTags []asepriteTag `json:"frameTags"`
+	} `json:"meta"`
+}
+
+var asepriteDirections = map[string]AnimationDirection{
+	"forward":          AnimationForward,
+	"reverse":          AnimationReverse,
+	"pingpong":         AnimationPingPong,
+	"pingpong_reverse": AnimationPingPongReverse,
+}
+
+// GetAsepriteSheet loads a sheet exported by Aseprite with --list-tags, along
+// with its texture and the animations its tags describe.  Aseprite writes
+// the same frames as TexturePacker, so the sheet may be a JSON array or hash.
+func GetAsepriteSheet(path string) (sheet *Spritesheet, animations Animations, err error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(path); err != nil {
+		return
+	}
+	if animations, err = ParseAsepriteAnimations(data); err != nil {
+		err = fmt.Errorf("Could not parse %v: %v", path, err)
+		return
+	}
+	if sheet, err = GetSpritesheet(path); err != nil {
+		return
+	}
+	return
+}
+
+// ParseAsepriteAnimations reads an animation for each tag of an Aseprite
+// sheet, or a single DefaultAnimation of every frame if it has no tags.
+func ParseAsepriteAnimations(data []byte) (animations Animations, err error) {
+	var (
+		doc    asepriteSheet
+		frames []texturePackerFrame
+	)
+	if err = json.Unmarshal(data, &doc); err != nil {
+		return
+	}
+	if frames, err = parseTexturePackerFrames(doc.Frames); err != nil {
+		return
+	}
+	var tags = doc.Meta.FrameTags
+	if len(tags) == 0 {
+		tags = []asepriteTag{{DefaultAnimation, 0, len(frames) - 1, "forward"}}
+	}
+	animations = Animations{}
+	for _, tag := range tags {
+		var (
+			animation = &Animation{Name: tag.Name}
+			ok        bool
+		)
+		if animation.Direction, ok = asepriteDirections[tag.Direction]; !ok {
+			err = fmt.Errorf("Tag %v has unknown direction %v", tag.Name, tag.Direction)
+			return
+		}
+		if tag.From < 0 || tag.To >= len(frames) || tag.From > tag.To {
+			err = fmt.Errorf("Tag %v runs from frame %v to %v of %v", tag.Name, tag.From, tag.To, len(frames))
+			return
+		}
+		for i := tag.From; i <= tag.To; i++ {
+			if frames[i].Duration <= 0 {
+				err = fmt.Errorf("Frame %v of tag %v has duration %vms", i, tag.Name, frames[i].Duration)
+				return
+			}
+			animation.Frames = append(animation.Frames, i)
+			animation.Durations = append(animation.Durations, time.Duration(frames[i].Duration)*time.Millisecond)
+		}
+		animations[tag.Name] = animation
+	}
+	return
+}
@@ -0,0 +1,74 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// asepriteTestJSON writes a sheet of frames shown for the given durations,
+// in milliseconds, and a single tag over all of them.
+func asepriteTestJSON(direction string, durations ...int) string {
+	var frames []string
+	for i, d := range durations {
+		frames = append(frames, fmt.Sprintf(`{"filename": "f%v", "frame": {"x": %v, "y": 0, "w": 1, "h": 1},
+			"spriteSourceSize": {"x": 0, "y": 0, "w": 1, "h": 1}, "sourceSize": {"w": 1, "h": 1}, "duration": %v}`, i, i, d))
+	}
+	return fmt.Sprintf(`{"frames": [%v], "meta": {"frameTags": [{"name": "tag", "from": 0, "to": %v, "direction": %q}]}}`,
+		strings.Join(frames, ","), len(durations)-1, direction)
+}
+
+// TestAsepriteDirections checks the order each tag direction shows frames
+// in, over two loops.
+func TestAsepriteDirections(t *testing.T) {
+	var tests = []struct {
+		direction string
+		frames    []int
+	}{
+		{"forward", []int{0, 1, 2, 3, 0, 1, 2, 3}},
+		{"reverse", []int{3, 2, 1, 0, 3, 2, 1, 0}},
+		{"pingpong", []int{0, 1, 2, 3, 2, 1, 0, 1, 2, 3, 2, 1}},
+		{"pingpong_reverse", []int{3, 2, 1, 0, 1, 2, 3, 2, 1, 0, 1, 2}},
+	}
+	for _, test := range tests {
+		var animations, err = ParseAsepriteAnimations([]byte(asepriteTestJSON(test.direction, 100, 100, 100, 100)))
+		if err != nil {
+			t.Fatalf("%v: %v", test.direction, err)
+		}
+		var (
+			animator = &Animator{}
+			frames   []int
+		)
+		animator.Play(animations["tag"])
+		for len(frames) < len(test.frames) {
+			frames = append(frames, animator.Frame())
+			animator.Update(animations["tag"].Durations[0])
+		}
+		if !reflect.DeepEqual(frames, test.frames) {
+			t.Errorf("%v: showed frames %v, want %v", test.direction, frames, test.frames)
+		}
+	}
+}
+
+func TestAsepriteDurations(t *testing.T) {
+	for _, durations := range [][]int{{100, 0}, {-5}} {
+		if _, err := ParseAsepriteAnimations([]byte(asepriteTestJSON("forward", durations...))); err == nil {
+			t.Errorf("Expected an error for durations %v", durations)
+		}
+	}
+}
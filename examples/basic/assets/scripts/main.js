@@ -4,3 +4,12 @@ addEventListener('foo', function (player) {
   console.log("Got 'foo' event! Player X:", pos.X, "Player Y:", pos.Y);
   player.MoveToCoords(pos.X + 1, pos.Y + 1);
 });
+addEventListener('level', function (levels) {
+  var next = levels.Next();
+  console.log("Switching from", levels.Name(), "to", next);
+  levels.Load(next);
+});
+addEventListener('sign', function (player, trigger) {
+  var pos = player.Pos();
+  console.log("Player at", pos.X, pos.Y, "passed trigger", trigger.Name);
+});
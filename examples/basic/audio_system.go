@@ -14,6 +14,7 @@ type AudioSystem struct {
 	clickObserverId       int
 	pauseMusicObserverId  int
 	resumeMusicObserverId int
+	animationObserverId   int
 }
 
 func (a *AudioSystem) PlayBGMusic(e twodee.GETyper) {
@@ -50,6 +51,14 @@ func (a *AudioSystem) PlayClick(e twodee.GETyper) {
 	a.click.Play(1)
 }
 
+// PlayAnimationSound plays sounds for animation frame events.  There is no
+// footstep sound yet, so footsteps borrow the menu click.
+func (a *AudioSystem) PlayAnimationSound(e twodee.GETyper) {
+	if event, ok := e.(*AnimationEvent); ok && event.Name == FootstepEvent {
+		a.click.Play(1)
+	}
+}
+
 func (a *AudioSystem) Delete() {
 	a.app.GameEventHandler.RemoveObserver(BGMusic, a.bgmusicObserverId)
 	a.app.GameEventHandler.RemoveObserver(MenuMusic, a.menumusicObserverId)
@@ -57,6 +66,7 @@ func (a *AudioSystem) Delete() {
 	a.app.GameEventHandler.RemoveObserver(MenuClick, a.clickObserverId)
 	a.app.GameEventHandler.RemoveObserver(PauseMusic, a.pauseMusicObserverId)
 	a.app.GameEventHandler.RemoveObserver(ResumeMusic, a.resumeMusicObserverId)
+	a.app.GameEventHandler.RemoveObserver(AnimationFrame, a.animationObserverId)
 	a.bgmusic.Delete()
 	a.menumusic.Delete()
 	a.click.Delete()
@@ -96,5 +106,6 @@ func NewAudioSystem(app *Application) (audioSystem *AudioSystem, err error) {
 	audioSystem.clickObserverId = app.GameEventHandler.AddObserver(MenuClick, audioSystem.PlayClick)
 	audioSystem.pauseMusicObserverId = app.GameEventHandler.AddObserver(PauseMusic, audioSystem.PauseMusic)
 	audioSystem.resumeMusicObserverId = app.GameEventHandler.AddObserver(ResumeMusic, audioSystem.ResumeMusic)
+	audioSystem.animationObserverId = app.GameEventHandler.AddObserver(AnimationFrame, audioSystem.PlayAnimationSound)
 	return
 }
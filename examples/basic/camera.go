@@ -0,0 +1,294 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	twodee "../../libs/twodee"
+	"math"
+	"time"
+)
+
+// CameraController moves a twodee.Camera to follow an entity.  The camera
+// stays still while the entity moves inside a deadzone around the focus,
+// eases toward its goal rather than jumping, can lead the entity in the
+// direction it is moving, and never shows anything outside Bounds.  It also
+// zooms in and out around a point, which stays put on screen as it does.
+type CameraController struct {
+	Camera *twodee.Camera
+	Target twodee.Entity
+
+	// Width and Height of the view in world units at a zoom of 1.
+	Width  float32
+	Height float32
+
+	// Zoom magnifies the view: at 2 it covers half the width and height.
+	// ZoomAt keeps the goal zoom between MinZoom and MaxZoom, and
+	// ZoomSmoothing works like Smoothing for animating toward it.
+	Zoom          float32
+	MinZoom       float32
+	MaxZoom       float32
+	ZoomSmoothing float32
+
+	// DeadzoneWidth and DeadzoneHeight size the box, centered on the focus,
+	// inside which the target can move without moving the camera.  They are
+	// given at a zoom of 1 and shrink as the camera zooms in.
+	DeadzoneWidth  float32
+	DeadzoneHeight float32
+
+	// Smoothing is how quickly the camera closes on its goal: the fraction
+	// of the distance left after one second is e^-Smoothing.  Zero snaps.
+	Smoothing float32
+
+	// LookAhead leads the target by its velocity times this many seconds.
+	LookAhead float32
+
+	// Bounds the view is kept inside.  An empty rectangle means no limit.
+	Bounds twodee.Rectangle
+
+	Center twodee.Point
+	focus  twodee.Point
+	lead   twodee.Point
+	last   twodee.Point
+
+	zoomGoal   float32
+	zoomAnchor twodee.Point
+	zoomFrac   twodee.Point
+
+	// The world bounds set by the last two updates, for Interpolate.
+	previous twodee.Rectangle
+	current  twodee.Rectangle
+	snapped  bool
+}
+
+func NewCameraController(camera *twodee.Camera, width, height float32) *CameraController {
+	var bounds = camera.WorldBounds
+	return &CameraController{
+		Camera:   camera,
+		Width:    width,
+		Height:   height,
+		Zoom:     1,
+		MinZoom:  1,
+		MaxZoom:  1,
+		zoomGoal: 1,
+		Center: twodee.Pt(
+			(bounds.Min.X+bounds.Max.X)/2,
+			(bounds.Min.Y+bounds.Max.Y)/2,
+		),
+	}
+}
+
+// Follow starts tracking an entity from wherever the camera is now.  A nil
+// entity leaves the camera where it is.
+func (c *CameraController) Follow(target twodee.Entity) {
+	c.Target = target
+	c.focus = c.Center
+	c.lead = twodee.Pt(0, 0)
+	if target != nil {
+		c.last = target.Pos()
+	}
+}
+
+// Snap centers the camera on the target immediately, for example after the
+// target has been moved to the start of a new level.
+func (c *CameraController) Snap() {
+	if c.Target != nil {
+		c.focus = c.Target.Pos()
+		c.last = c.focus
+	}
+	c.lead = twodee.Pt(0, 0)
+	c.Center = c.clamp(c.focus)
+	c.snapped = true
+}
+
+// Pan stops following and moves the camera by (dx, dy).
+func (c *CameraController) Pan(dx, dy float32) {
+	c.Target = nil
+	c.Center = c.clamp(twodee.Pt(c.Center.X+dx, c.Center.Y+dy))
+}
+
+// LookAt stops following and centers the camera on a point.
+func (c *CameraController) LookAt(pt twodee.Point) {
+	c.Target = nil
+	c.Center = c.clamp(pt)
+}
+
+// ZoomAt multiplies the goal zoom by factor, animating toward it while
+// keeping the world point anchor at the same place on screen.
+func (c *CameraController) ZoomAt(factor float32, anchor twodee.Point) {
+	var view = c.View()
+	c.zoomGoal = c.clampZoom(c.zoomGoal * factor)
+	c.zoomAnchor = anchor
+	c.zoomFrac = twodee.Pt(
+		(anchor.X-view.Min.X)/(view.Max.X-view.Min.X),
+		(anchor.Y-view.Min.Y)/(view.Max.Y-view.Min.Y),
+	)
+}
+
+// SetZoom changes the zoom straight away, keeping the center where it is.
+func (c *CameraController) SetZoom(zoom float32) {
+	c.Zoom = c.clampZoom(zoom)
+	c.zoomGoal = c.Zoom
+	c.Center = c.clamp(c.Center)
+}
+
+func (c *CameraController) clampZoom(zoom float32) float32 {
+	if zoom < c.MinZoom {
+		return c.MinZoom
+	}
+	if zoom > c.MaxZoom {
+		return c.MaxZoom
+	}
+	return zoom
+}
+
+// updateZoom steps the zoom toward its goal and moves the center so the
+// anchor stays fixed on screen.  The focus moves with it, so that following
+// carries on from the new view instead of pulling back to the old one.
+func (c *CameraController) updateZoom(dt float32) {
+	if c.Zoom == c.zoomGoal {
+		return
+	}
+	if c.ZoomSmoothing <= 0 {
+		c.Zoom = c.zoomGoal
+	} else {
+		c.Zoom += (c.zoomGoal - c.Zoom) * (1 - float32(math.Exp(float64(-c.ZoomSmoothing*dt))))
+		if math.Abs(float64(c.zoomGoal-c.Zoom)) < 0.001 {
+			c.Zoom = c.zoomGoal
+		}
+	}
+	var (
+		w, h   = c.viewSize()
+		center = c.clamp(twodee.Pt(
+			c.zoomAnchor.X+(0.5-c.zoomFrac.X)*w,
+			c.zoomAnchor.Y+(0.5-c.zoomFrac.Y)*h,
+		))
+	)
+	c.focus.X += center.X - c.Center.X
+	c.focus.Y += center.Y - c.Center.Y
+	c.Center = center
+}
+
+func (c *CameraController) viewSize() (w, h float32) {
+	return c.Width / c.Zoom, c.Height / c.Zoom
+}
+
+// Update moves the camera toward the target and sets the camera's world
+// bounds, displaced by offset so that effects such as shake are applied on
+// top of following.
+func (c *CameraController) Update(elapsed time.Duration, offset twodee.Point) {
+	var dt = float32(elapsed.Seconds())
+	c.updateZoom(dt)
+	if c.Target != nil && dt > 0 {
+		var pos = c.Target.Pos()
+		c.focus.X = deadzone(c.focus.X, pos.X, c.DeadzoneWidth/c.Zoom/2)
+		c.focus.Y = deadzone(c.focus.Y, pos.Y, c.DeadzoneHeight/c.Zoom/2)
+		var lead = twodee.Pt(
+			(pos.X-c.last.X)/dt*c.LookAhead,
+			(pos.Y-c.last.Y)/dt*c.LookAhead,
+		)
+		c.last = pos
+		c.lead = c.ease(c.lead, lead, dt)
+		c.Center = c.ease(c.Center, c.clamp(twodee.Pt(c.focus.X+c.lead.X, c.focus.Y+c.lead.Y)), dt)
+	}
+	var view = c.View()
+	c.previous = c.current
+	c.current = twodee.Rect(
+		view.Min.X+offset.X,
+		view.Min.Y+offset.Y,
+		view.Max.X+offset.X,
+		view.Max.Y+offset.Y,
+	)
+	if c.snapped || c.previous == (twodee.Rectangle{}) {
+		c.previous = c.current
+		c.snapped = false
+	}
+	c.Camera.SetWorldBounds(c.current)
+}
+
+// Interpolate sets the camera's world bounds alpha of the way from where
+// the update before last left them, at 0, to where the last update did, at
+// 1.  A snap is not interpolated across, and before the first update the
+// camera is left alone.
+func (c *CameraController) Interpolate(alpha float32) {
+	var (
+		from = c.previous
+		to   = c.current
+	)
+	if to == (twodee.Rectangle{}) {
+		return
+	}
+	c.Camera.SetWorldBounds(twodee.Rect(
+		from.Min.X+(to.Min.X-from.Min.X)*alpha,
+		from.Min.Y+(to.Min.Y-from.Min.Y)*alpha,
+		from.Max.X+(to.Max.X-from.Max.X)*alpha,
+		from.Max.Y+(to.Max.Y-from.Max.Y)*alpha,
+	))
+}
+
+// View returns the area the camera shows, before any offset.
+func (c *CameraController) View() twodee.Rectangle {
+	var w, h = c.viewSize()
+	return twodee.Rect(
+		c.Center.X-w/2,
+		c.Center.Y-h/2,
+		c.Center.X+w/2,
+		c.Center.Y+h/2,
+	)
+}
+
+// ease moves from toward to by the fraction Smoothing allows in dt seconds.
+func (c *CameraController) ease(from, to twodee.Point, dt float32) twodee.Point {
+	if c.Smoothing <= 0 {
+		return to
+	}
+	var t = 1 - float32(math.Exp(float64(-c.Smoothing*dt)))
+	return twodee.Pt(from.X+(to.X-from.X)*t, from.Y+(to.Y-from.Y)*t)
+}
+
+// clamp keeps a center point far enough inside Bounds that the whole view
+// fits.  A view larger than Bounds is centered on them instead.
+func (c *CameraController) clamp(pt twodee.Point) twodee.Point {
+	if c.Bounds.Min == c.Bounds.Max {
+		return pt
+	}
+	var w, h = c.viewSize()
+	pt.X = clampAxis(pt.X, c.Bounds.Min.X, c.Bounds.Max.X, w/2)
+	pt.Y = clampAxis(pt.Y, c.Bounds.Min.Y, c.Bounds.Max.Y, h/2)
+	return pt
+}
+
+func clampAxis(v, min, max, half float32) float32 {
+	if max-min < half*2 {
+		return (min + max) / 2
+	}
+	if v < min+half {
+		return min + half
+	}
+	if v > max-half {
+		return max - half
+	}
+	return v
+}
+
+// deadzone moves focus just far enough that target is within half of it.
+func deadzone(focus, target, half float32) float32 {
+	if target < focus-half {
+		return target + half
+	}
+	if target > focus+half {
+		return target - half
+	}
+	return focus
+}
@@ -0,0 +1,105 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	twodee "../../libs/twodee"
+	"github.com/kurrik/tmxgo"
+)
+
+// LevelChunkSize is the width and height of a layer chunk, in tiles.
+const LevelChunkSize int32 = 32
+
+// LevelChunk is a square of tiles from one layer with its own batch.  The
+// batch is only loaded the first time the chunk is drawn, so large maps pay
+// for the parts that are actually seen.
+type LevelChunk struct {
+	Bounds twodee.Rectangle
+	Batch  *twodee.Batch
+	tiles  []twodee.TexturedTile
+}
+
+// Load creates the chunk's batch if it has not been already, drawing from
+// the texture shared by every chunk in its layer.
+func (c *LevelChunk) Load(texture *twodee.Texture, metadata twodee.TileMetadata) (err error) {
+	if c.Batch != nil {
+		return
+	}
+	if c.Batch, err = twodee.NewBatch(c.tiles, texture, metadata); err != nil {
+		return
+	}
+	return
+}
+
+// Delete frees the chunk's batch, leaving the layer's texture alone.
+func (c *LevelChunk) Delete() {
+	if c.Batch != nil {
+		c.Batch.Delete()
+		c.Batch = nil
+	}
+}
+
+// GetLevelChunks splits a layer's tiles into chunks of size tiles on a side,
+// dropping any with no tiles at all.  A size of 0 puts the whole layer in a
+// single chunk.  tileWidth and tileHeight are in world units, and bounds
+// assume the map's bottom left corner is at the world origin.
+func GetLevelChunks(m *tmxgo.Map, tiles []*tmxgo.Tile, size int32, tileWidth, tileHeight float32) (chunks []*LevelChunk) {
+	if size <= 0 {
+		size = m.Width
+		if m.Height > size {
+			size = m.Height
+		}
+	}
+	for row0 := int32(0); row0 < m.Height; row0 += size {
+		for col0 := int32(0); col0 < m.Width; col0 += size {
+			var (
+				row1  = minInt32(row0+size, m.Height)
+				col1  = minInt32(col0+size, m.Width)
+				chunk = &LevelChunk{
+					Bounds: twodee.Rect(
+						float32(col0)*tileWidth,
+						float32(m.Height-row1)*tileHeight,
+						float32(col1)*tileWidth,
+						float32(m.Height-row0)*tileHeight,
+					),
+				}
+			)
+			for row := row0; row < row1; row++ {
+				for col := col0; col < col1; col++ {
+					if t := tiles[row*m.Width+col]; t != nil {
+						chunk.tiles = append(chunk.tiles, t)
+					}
+				}
+			}
+			if len(chunk.tiles) > 0 {
+				chunks = append(chunks, chunk)
+			}
+		}
+	}
+	return
+}
+
+func minInt32(a, b int32) int32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// overlaps reports whether two rectangles share any area.
+func overlaps(a, b twodee.Rectangle) bool {
+	return a.Min.X < b.Max.X && b.Min.X < a.Max.X &&
+		a.Min.Y < b.Max.Y && b.Min.Y < a.Max.Y
+}
@@ -0,0 +1,168 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	twodee "../../libs/twodee"
+	"github.com/go-gl/gl/v3.3-core/gl"
+	"github.com/kurrik/tmxgo"
+	"reflect"
+	"testing"
+)
+
+// chunkTestMap is 5 tiles wide and 3 high, with tiles half a unit high and
+// the bottom row empty in its middle columns.
+func chunkTestMap() (m *tmxgo.Map, tiles []*tmxgo.Tile) {
+	m = &tmxgo.Map{Width: 5, Height: 3, TileWidth: 16, TileHeight: 8}
+	tiles = make([]*tmxgo.Tile, m.Width*m.Height)
+	for i := range tiles {
+		if row, col := int32(i)/m.Width, int32(i)%m.Width; row != 2 || col < 2 || col > 3 {
+			tiles[i] = &tmxgo.Tile{}
+		}
+	}
+	return
+}
+
+func TestGetLevelChunks(t *testing.T) {
+	var m, tiles = chunkTestMap()
+	var tests = []struct {
+		size   int32
+		bounds []twodee.Rectangle
+		counts []int
+	}{
+		{
+			size:   0,
+			bounds: []twodee.Rectangle{twodee.Rect(0, 0, 5, 1.5)},
+			counts: []int{13},
+		},
+		{
+			size: 2,
+			bounds: []twodee.Rectangle{
+				twodee.Rect(0, 0.5, 2, 1.5),
+				twodee.Rect(2, 0.5, 4, 1.5),
+				twodee.Rect(4, 0.5, 5, 1.5),
+				twodee.Rect(0, 0, 2, 0.5),
+				twodee.Rect(4, 0, 5, 0.5),
+			},
+			counts: []int{4, 4, 2, 2, 1},
+		},
+	}
+	for _, test := range tests {
+		var chunks = GetLevelChunks(m, tiles, test.size, 1, 0.5)
+		if len(chunks) != len(test.bounds) {
+			t.Fatalf("Size %v: got %v chunks, want %v", test.size, len(chunks), len(test.bounds))
+		}
+		for i, chunk := range chunks {
+			if chunk.Bounds != test.bounds[i] || len(chunk.tiles) != test.counts[i] {
+				t.Errorf("Size %v chunk %v: got %v with %v tiles, want %v with %v",
+					test.size, i, chunk.Bounds, len(chunk.tiles), test.bounds[i], test.counts[i])
+			}
+		}
+	}
+}
+
+// TestLevelChunksVisible checks which chunks a layer draws for a view.
+// Chunks which only touch the view's edge are not drawn.
+func TestLevelChunksVisible(t *testing.T) {
+	var (
+		m, tiles = chunkTestMap()
+		chunks   = GetLevelChunks(m, tiles, 2, 1, 0.5)
+	)
+	var tests = []struct {
+		view    twodee.Rectangle
+		visible []int
+	}{
+		{twodee.Rect(1.5, 0.2, 3, 0.5), []int{3}},
+		{twodee.Rect(1.9, 0.4, 2.1, 0.6), []int{0, 1, 3}},
+		{twodee.Rect(2, 0, 4, 0.5), nil},
+		{twodee.Rect(-1, -1, 6, 2), []int{0, 1, 2, 3, 4}},
+		{twodee.Rect(6, 0, 8, 1), nil},
+	}
+	for _, test := range tests {
+		var visible []int
+		for i, chunk := range chunks {
+			if overlaps(chunk.Bounds, test.view) {
+				visible = append(visible, i)
+			}
+		}
+		if !reflect.DeepEqual(visible, test.visible) {
+			t.Errorf("View %v: drew chunks %v, want %v", test.view, visible, test.visible)
+		}
+	}
+}
+
+// benchmarkLevelDraw draws every visible layer of the benchmark level through
+// a view the size of the game's, split into chunks of chunkSize tiles.
+func benchmarkLevelDraw(b *testing.B, chunkSize int32) {
+	requireWindow(b)
+	var (
+		level    *Level
+		camera   *twodee.Camera
+		renderer *twodee.BatchRenderer
+		layer    *LevelLayer
+		layers   []*LevelLayer
+		view     = twodee.Rect(0, 0, benchmarkViewSize, benchmarkViewSize)
+		err      error
+	)
+	if level, err = GetLevel("benchmark", benchmarkLevel, benchmarkSeed); err != nil {
+		b.Fatal(err)
+	}
+	if camera, err = twodee.NewCamera(view, twodee.Rect(0, 0, benchmarkWindowSize, benchmarkWindowSize)); err != nil {
+		b.Fatal(err)
+	}
+	if renderer, err = twodee.NewBatchRenderer(camera); err != nil {
+		b.Fatal(err)
+	}
+	defer renderer.Delete()
+	for _, name := range level.Doc.LayerNames() {
+		if !level.Doc.LayerVisible(name) {
+			continue
+		}
+		if layer, err = GetLevelLayer(level.Map, level.Dir, name, chunkSize); err != nil {
+			b.Fatal(err)
+		}
+		if layer != nil {
+			defer layer.Delete()
+			layers = append(layers, layer)
+		}
+	}
+	draw := func() {
+		renderer.Bind()
+		for _, layer := range layers {
+			if err := layer.Draw(renderer, view, 0, 0, 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+		renderer.Unbind()
+		// Wait for the GPU, so its share of the work is timed too.
+		gl.Finish()
+	}
+	// Load the visible chunks before timing, so only drawing is measured.
+	draw()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		draw()
+	}
+}
+
+// BenchmarkLevelDrawSingleBatch draws each layer as one batch, as the game did
+// before layers were chunked.
+func BenchmarkLevelDrawSingleBatch(b *testing.B) {
+	benchmarkLevelDraw(b, 0)
+}
+
+func BenchmarkLevelDrawChunked(b *testing.B) {
+	benchmarkLevelDraw(b, LevelChunkSize)
+}
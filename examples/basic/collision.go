@@ -0,0 +1,212 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	twodee "../../libs/twodee"
+	"math"
+)
+
+// CollisionGrid places a twodee.Grid in world space.  Grid row 0 is the top
+// row of the map, matching the TMX layer it was built from, while world Y
+// points up from the bottom of the map.  Cells outside the grid are solid.
+type CollisionGrid struct {
+	Grid       *twodee.Grid
+	TileWidth  float32
+	TileHeight float32
+}
+
+func NewCollisionGrid(grid *twodee.Grid, tileWidth, tileHeight float32) *CollisionGrid {
+	return &CollisionGrid{
+		Grid:       grid,
+		TileWidth:  tileWidth,
+		TileHeight: tileHeight,
+	}
+}
+
+// Blocked reports whether a cell is solid.  Rows count up from the bottom
+// of the map, like world Y.
+func (c *CollisionGrid) Blocked(col, row int32) bool {
+	if col < 0 || row < 0 || col >= c.Grid.Width || row >= c.Grid.Height {
+		return true
+	}
+	return c.Grid.Get(col, c.Grid.Height-1-row)
+}
+
+// WorldToTile returns the column and bottom-up row containing a world point.
+func (c *CollisionGrid) WorldToTile(x, y float32) (col, row int32) {
+	col = int32(math.Floor(float64(x / c.TileWidth)))
+	row = int32(math.Floor(float64(y / c.TileHeight)))
+	return
+}
+
+// TileToWorld returns the world coordinates of the center of a cell.
+func (c *CollisionGrid) TileToWorld(col, row int32) (x, y float32) {
+	x = (float32(col) + 0.5) * c.TileWidth
+	y = (float32(row) + 0.5) * c.TileHeight
+	return
+}
+
+// collisionEpsilon is how far into a cell, as a fraction of the cell, an
+// edge must reach to overlap it.  Edges left on a cell boundary by a collision
+// then never overlap the wall they stopped against through rounding.
+const collisionEpsilon = 1e-4
+
+// tileSpan returns the cells of the given size which the span from lo to hi
+// overlaps along one axis.
+func tileSpan(lo, hi, size float32) (first, last int32) {
+	first = int32(math.Floor(float64(lo/size + collisionEpsilon)))
+	last = int32(math.Ceil(float64(hi/size-collisionEpsilon))) - 1
+	return
+}
+
+// blockedColumn reports whether any cell of a column from row0 to row1 is
+// solid.
+func (c *CollisionGrid) blockedColumn(col, row0, row1 int32) bool {
+	for row := row0; row <= row1; row++ {
+		if c.Blocked(col, row) {
+			return true
+		}
+	}
+	return false
+}
+
+// blockedRow reports whether any cell of a row from col0 to col1 is solid.
+func (c *CollisionGrid) blockedRow(row, col0, col1 int32) bool {
+	for col := col0; col <= col1; col++ {
+		if c.Blocked(col, row) {
+			return true
+		}
+	}
+	return false
+}
+
+// Contacts records which sides of a body were stopped by a wall.
+type Contacts struct {
+	Left   bool
+	Right  bool
+	Top    bool
+	Bottom bool
+}
+
+func (c Contacts) Any() bool {
+	return c.Left || c.Right || c.Top || c.Bottom
+}
+
+// Collider is an axis aligned box centered on an entity which moves through
+// a CollisionGrid.  It is the collider component of entities in a World.
+type Collider struct {
+	Width    float32
+	Height   float32
+	Contacts Contacts
+}
+
+// Bounds returns the box centered on a point.
+func (b *Collider) Bounds(center twodee.Point) twodee.Rectangle {
+	return twodee.Rect(
+		center.X-b.Width/2,
+		center.Y-b.Height/2,
+		center.X+b.Width/2,
+		center.Y+b.Height/2,
+	)
+}
+
+// Move displaces the box centered on a point by (dx, dy) and returns where
+// its center ends up, resolving X and then Y against the grid so that a
+// blocked axis slides along the wall instead of stopping dead.  Long moves
+// are split into steps of less than a cell so thin walls cannot be skipped.
+// The sides which hit something are stored in Contacts.
+func (b *Collider) Move(grid *CollisionGrid, center twodee.Point, dx, dy float32) twodee.Point {
+	var (
+		bounds = b.Bounds(center)
+		steps  = int(math.Ceil(math.Max(
+			math.Abs(float64(dx/grid.TileWidth)),
+			math.Abs(float64(dy/grid.TileHeight)),
+		)*2)) + 1
+		sx = dx / float32(steps)
+		sy = dy / float32(steps)
+	)
+	b.Contacts = Contacts{}
+	for i := 0; i < steps; i++ {
+		if sx != 0 && !b.Contacts.Left && !b.Contacts.Right {
+			bounds = b.moveX(grid, bounds, sx)
+		}
+		if sy != 0 && !b.Contacts.Top && !b.Contacts.Bottom {
+			bounds = b.moveY(grid, bounds, sy)
+		}
+	}
+	return twodee.Pt(
+		(bounds.Min.X+bounds.Max.X)/2,
+		(bounds.Min.Y+bounds.Max.Y)/2,
+	)
+}
+
+// moveX moves the box along X until its leading edge meets a solid cell.
+// Only the cells the leading edge moves into are checked, so a box which
+// starts overlapping a wall is free to move out of it.
+func (b *Collider) moveX(grid *CollisionGrid, bounds twodee.Rectangle, dx float32) twodee.Rectangle {
+	var (
+		moved      = twodee.Rect(bounds.Min.X+dx, bounds.Min.Y, bounds.Max.X+dx, bounds.Max.Y)
+		row0, row1 = tileSpan(bounds.Min.Y, bounds.Max.Y, grid.TileHeight)
+		col0, col1 = tileSpan(bounds.Min.X, bounds.Max.X, grid.TileWidth)
+		to0, to1   = tileSpan(moved.Min.X, moved.Max.X, grid.TileWidth)
+	)
+	if dx > 0 {
+		for col := col1 + 1; col <= to1; col++ {
+			if grid.blockedColumn(col, row0, row1) {
+				var edge = float32(col) * grid.TileWidth
+				b.Contacts.Right = true
+				return twodee.Rect(edge-b.Width, moved.Min.Y, edge, moved.Max.Y)
+			}
+		}
+	} else {
+		for col := col0 - 1; col >= to0; col-- {
+			if grid.blockedColumn(col, row0, row1) {
+				var edge = float32(col+1) * grid.TileWidth
+				b.Contacts.Left = true
+				return twodee.Rect(edge, moved.Min.Y, edge+b.Width, moved.Max.Y)
+			}
+		}
+	}
+	return moved
+}
+
+// moveY moves the box along Y like moveX does along X.
+func (b *Collider) moveY(grid *CollisionGrid, bounds twodee.Rectangle, dy float32) twodee.Rectangle {
+	var (
+		moved      = twodee.Rect(bounds.Min.X, bounds.Min.Y+dy, bounds.Max.X, bounds.Max.Y+dy)
+		col0, col1 = tileSpan(bounds.Min.X, bounds.Max.X, grid.TileWidth)
+		row0, row1 = tileSpan(bounds.Min.Y, bounds.Max.Y, grid.TileHeight)
+		to0, to1   = tileSpan(moved.Min.Y, moved.Max.Y, grid.TileHeight)
+	)
+	if dy > 0 {
+		for row := row1 + 1; row <= to1; row++ {
+			if grid.blockedRow(row, col0, col1) {
+				var edge = float32(row) * grid.TileHeight
+				b.Contacts.Top = true
+				return twodee.Rect(moved.Min.X, edge-b.Height, moved.Max.X, edge)
+			}
+		}
+	} else {
+		for row := row0 - 1; row >= to0; row-- {
+			if grid.blockedRow(row, col0, col1) {
+				var edge = float32(row+1) * grid.TileHeight
+				b.Contacts.Bottom = true
+				return twodee.Rect(moved.Min.X, edge, moved.Max.X, edge+b.Height)
+			}
+		}
+	}
+	return moved
+}
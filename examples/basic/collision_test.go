@@ -0,0 +1,71 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	twodee "../../libs/twodee"
+	"math"
+	"testing"
+)
+
+// TestColliderMove moves a half unit box through small grids of unit cells.
+// Rows are given top first, so world Y 0 is the bottom of the last row.
+func TestColliderMove(t *testing.T) {
+	var (
+		room = []string{
+			"#####",
+			"#...#",
+			"#...#",
+			"#...#",
+			"#####",
+		}
+		thinWall = []string{
+			"#######",
+			"#..#..#",
+			"#######",
+		}
+	)
+	var tests = []struct {
+		name     string
+		rows     []string
+		from     twodee.Point
+		dx, dy   float32
+		to       twodee.Point
+		contacts Contacts
+	}{
+		{"free", room, twodee.Pt(2, 2), 0.5, -0.25, twodee.Pt(2.5, 1.75), Contacts{}},
+		{"slide along the ceiling", room, twodee.Pt(2, 3.7), 0.5, 0.5, twodee.Pt(2.5, 3.75), Contacts{Top: true}},
+		{"slide along the floor", room, twodee.Pt(2, 1.25), -0.5, 0, twodee.Pt(1.5, 1.25), Contacts{}},
+		{"slide along a wall", room, twodee.Pt(3.7, 2), 0.5, -0.5, twodee.Pt(3.75, 1.5), Contacts{Right: true}},
+		{"stop in a corner", room, twodee.Pt(3.5, 3.5), 1, 1, twodee.Pt(3.75, 3.75), Contacts{Right: true, Top: true}},
+		{"stop in the other corner", room, twodee.Pt(1.5, 1.5), -1, -1, twodee.Pt(1.25, 1.25), Contacts{Left: true, Bottom: true}},
+		{"substep into a thin wall", thinWall, twodee.Pt(1.5, 1.5), 10, 0, twodee.Pt(2.75, 1.5), Contacts{Right: true}},
+		{"substep back", thinWall, twodee.Pt(5.5, 1.5), -10, 0, twodee.Pt(4.25, 1.5), Contacts{Left: true}},
+		{"start inside a wall", room, twodee.Pt(0.6, 2.5), 0.2, 0, twodee.Pt(0.8, 2.5), Contacts{}},
+	}
+	for _, test := range tests {
+		var (
+			grid     = NewCollisionGrid(newTestGrid(test.rows...), 1, 1)
+			collider = &Collider{Width: 0.5, Height: 0.5}
+			to       = collider.Move(grid, test.from, test.dx, test.dy)
+		)
+		if math.Abs(float64(to.X-test.to.X)) > 1e-4 || math.Abs(float64(to.Y-test.to.Y)) > 1e-4 {
+			t.Errorf("%v: moved to %v, want %v", test.name, to, test.to)
+		}
+		if collider.Contacts != test.contacts {
+			t.Errorf("%v: got contacts %+v, want %+v", test.name, collider.Contacts, test.contacts)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	twodee "../../libs/twodee"
+	tiled "../tiled"
+	"container/heap"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const (
+	// Cost of a cell which cannot be entered.
+	CostBlocked float32 = -1
+
+	// Tile property holding the cost of walking over a tile.
+	CostProperty = "cost"
+)
+
+// CostGrid holds the cost of entering each cell of a map.  Cells are indexed
+// like twodee.Grid, with row 0 at the top.
+type CostGrid struct {
+	Width  int32
+	Height int32
+	Costs  []float32
+}
+
+func NewCostGrid(width, height int32) *CostGrid {
+	var g = &CostGrid{
+		Width:  width,
+		Height: height,
+		Costs:  make([]float32, width*height),
+	}
+	for i := range g.Costs {
+		g.Costs[i] = 1
+	}
+	return g
+}
+
+// NewCostGridFromGrid gives every blocked cell of a collision grid
+// CostBlocked and every other cell a cost of 1.
+func NewCostGridFromGrid(grid *twodee.Grid) *CostGrid {
+	var g = NewCostGrid(grid.Width, grid.Height)
+	g.Block(grid)
+	return g
+}
+
+// NewCostGridFromLayer maps the tiles of a layer to costs.  Tiles missing
+// from costs, including empty cells, get the default cost.
+func NewCostGridFromLayer(layer *tiled.TileLayer, costs map[uint32]float32, def float32) *CostGrid {
+	var g = NewCostGrid(layer.Width, layer.Height)
+	for i, gid := range layer.GIDs {
+		if cost, ok := costs[gid]; ok {
+			g.Costs[i] = cost
+		} else {
+			g.Costs[i] = def
+		}
+	}
+	return g
+}
+
+// NewCostGridFromTileProperties reads the "cost" property of the tiles in a
+// layer from the document's tilesets.
+func NewCostGridFromTileProperties(doc *tiled.TmxDocument, name string) (g *CostGrid, err error) {
+	var (
+		layer *tiled.TileLayer
+		costs = map[uint32]float32{}
+	)
+	if layer, err = doc.Layer(name); err != nil {
+		return
+	}
+	if layer == nil {
+		err = fmt.Errorf("No layer named %v", name)
+		return
+	}
+	for gid, props := range doc.TileProperties() {
+		value, ok := props[CostProperty]
+		if !ok {
+			continue
+		}
+		var cost float64
+		if cost, err = strconv.ParseFloat(value, 32); err != nil {
+			err = fmt.Errorf("Tile %v: bad cost %q", gid, value)
+			return
+		}
+		costs[gid] = float32(cost)
+	}
+	g = NewCostGridFromLayer(layer, costs, 1)
+	return
+}
+
+// Block marks every blocked cell of a collision grid as CostBlocked.
+func (g *CostGrid) Block(grid *twodee.Grid) {
+	for i := range g.Costs {
+		if grid.GetIndex(int32(i)) {
+			g.Costs[i] = CostBlocked
+		}
+	}
+}
+
+func (g *CostGrid) Get(x, y int32) float32 {
+	if x < 0 || y < 0 || x >= g.Width || y >= g.Height {
+		return CostBlocked
+	}
+	return g.Costs[y*g.Width+x]
+}
+
+func (g *CostGrid) Passable(x, y int32) bool {
+	return g.Get(x, y) >= 0
+}
+
+// minCost is the cheapest passable cell, which keeps the A* heuristic from
+// overestimating on weighted grids.
+func (g *CostGrid) minCost() float32 {
+	var min float32 = -1
+	for _, c := range g.Costs {
+		if c >= 0 && (min < 0 || c < min) {
+			min = c
+		}
+	}
+	return min
+}
+
+// PathOptions controls CostGrid.FindPath.
+type PathOptions struct {
+	// Diagonal allows moves to the eight surrounding cells rather than four.
+	// Diagonal moves cost the entered cell's cost times the square root of 2.
+	Diagonal bool
+
+	// CutCorners allows a diagonal move past a blocked orthogonal neighbor.
+	// By default both cells beside a diagonal move must be passable.
+	CutCorners bool
+
+	// MaxExpanded stops the search after this many cells have been
+	// expanded.  Zero means no limit.
+	MaxExpanded int
+}
+
+type pathNode struct {
+	index  int32
+	cost   float32
+	score  float32
+	parent int32
+	open   bool
+	closed bool
+	heap   int
+}
+
+type pathHeap []*pathNode
+
+func (h pathHeap) Len() int { return len(h) }
+
+func (h pathHeap) Less(i, j int) bool {
+	if h[i].score == h[j].score {
+		// Prefer nodes further along, which keeps ties close to the goal.
+		return h[i].cost > h[j].cost
+	}
+	return h[i].score < h[j].score
+}
+
+func (h pathHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heap = i
+	h[j].heap = j
+}
+
+func (h *pathHeap) Push(x interface{}) {
+	var n = x.(*pathNode)
+	n.heap = len(*h)
+	*h = append(*h, n)
+}
+
+func (h *pathHeap) Pop() interface{} {
+	var (
+		old = *h
+		n   = old[len(old)-1]
+	)
+	*h = old[:len(old)-1]
+	return n
+}
+
+var (
+	pathOrthogonal = [][2]int32{{1, 0}, {-1, 0}, {0, 1}, {0, -1}}
+	pathDiagonal   = [][2]int32{{1, 1}, {1, -1}, {-1, 1}, {-1, -1}}
+)
+
+// FindPath runs A* from (x1, y1) to (x2, y2) and returns every cell along the
+// cheapest path, including both ends, in the same form as Grid.GetPath.  On
+// a grid where every passable cell costs the same, a four way search finds a
+// path exactly as long as Grid.GetPath does.
+func (g *CostGrid) FindPath(x1, y1, x2, y2 int32, opts PathOptions) (path []twodee.Point, err error) {
+	if !g.Passable(x1, y1) {
+		return nil, fmt.Errorf("Start %v,%v is blocked", x1, y1)
+	}
+	if !g.Passable(x2, y2) {
+		return nil, fmt.Errorf("Goal %v,%v is blocked", x2, y2)
+	}
+	var (
+		nodes    = map[int32]*pathNode{}
+		open     = &pathHeap{}
+		min      = g.minCost()
+		expanded = 0
+		goal     = y2*g.Width + x2
+		start    = &pathNode{index: y1*g.Width + x1, parent: -1, open: true}
+	)
+	heuristic := func(x, y int32) float32 {
+		var dx, dy = float32(x - x2), float32(y - y2)
+		if dx < 0 {
+			dx = -dx
+		}
+		if dy < 0 {
+			dy = -dy
+		}
+		if !opts.Diagonal {
+			return (dx + dy) * min
+		}
+		if dx < dy {
+			dx, dy = dy, dx
+		}
+		return (dx + (math.Sqrt2-1)*dy) * min
+	}
+	start.score = heuristic(x1, y1)
+	nodes[start.index] = start
+	heap.Push(open, start)
+	for open.Len() > 0 {
+		var (
+			node = heap.Pop(open).(*pathNode)
+			x    = node.index % g.Width
+			y    = node.index / g.Width
+		)
+		node.open = false
+		node.closed = true
+		if node.index == goal {
+			for n := node; n != nil; n = nodes[n.parent] {
+				path = append(path, twodee.Pt(float32(n.index%g.Width), float32(n.index/g.Width)))
+			}
+			for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+				path[i], path[j] = path[j], path[i]
+			}
+			return
+		}
+		if expanded++; opts.MaxExpanded > 0 && expanded > opts.MaxExpanded {
+			return nil, fmt.Errorf("Gave up after expanding %v cells", opts.MaxExpanded)
+		}
+		g.expand(node, x, y, pathOrthogonal, 1, opts, nodes, open, heuristic)
+		if opts.Diagonal {
+			g.expand(node, x, y, pathDiagonal, math.Sqrt2, opts, nodes, open, heuristic)
+		}
+	}
+	return nil, fmt.Errorf("No path from %v,%v to %v,%v", x1, y1, x2, y2)
+}
+
+func (g *CostGrid) expand(node *pathNode, x, y int32, dirs [][2]int32, scale float32, opts PathOptions, nodes map[int32]*pathNode, open *pathHeap, heuristic func(x, y int32) float32) {
+	for _, d := range dirs {
+		var nx, ny = x + d[0], y + d[1]
+		if !g.Passable(nx, ny) {
+			continue
+		}
+		if d[0] != 0 && d[1] != 0 && !opts.CutCorners {
+			if !g.Passable(x+d[0], y) || !g.Passable(x, y+d[1]) {
+				continue
+			}
+		}
+		var (
+			index    = ny*g.Width + nx
+			cost     = node.cost + g.Get(nx, ny)*scale
+			next, ok = nodes[index]
+		)
+		if !ok {
+			next = &pathNode{index: index}
+			nodes[index] = next
+		} else if next.closed || cost >= next.cost {
+			continue
+		}
+		next.cost = cost
+		next.score = cost + heuristic(nx, ny)
+		next.parent = node.index
+		if next.open {
+			heap.Fix(open, next.heap)
+		} else {
+			next.open = true
+			heap.Push(open, next)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	twodee "../../libs/twodee"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// newTestGrid builds a collision grid from rows of text, with row 0 at the
+// top like twodee.Grid.  '#' marks a blocked cell.
+func newTestGrid(rows ...string) *twodee.Grid {
+	var grid = twodee.NewGrid(int32(len(rows[0])), int32(len(rows)))
+	for y, row := range rows {
+		for x, c := range row {
+			if c == '#' {
+				grid.SetIndex(int32(y*len(row)+x), true)
+			}
+		}
+	}
+	return grid
+}
+
+// TestFindPathMatchesGridGetPath checks that on a grid where every passable
+// cell costs the same, a four way FindPath is exactly as long as
+// twodee.Grid.GetPath, and fails where it fails.
+func TestFindPathMatchesGridGetPath(t *testing.T) {
+	var (
+		grid = newTestGrid(
+			"..........",
+			".######...",
+			"......#...",
+			"####..#.##",
+			"...#..#...",
+			"...#..###.",
+			"...#......",
+			"####.####.",
+			"..#.......",
+			"..#.......",
+		)
+		costs = NewCostGridFromGrid(grid)
+		pairs = [][4]int32{
+			{0, 0, 9, 9},
+			{0, 2, 5, 2},
+			{9, 0, 4, 8},
+			{7, 4, 0, 9},
+			{0, 0, 1, 1}, // blocked goal
+			{0, 9, 9, 9}, // walled in
+			{0, 4, 9, 0}, // walled in
+		}
+	)
+	for _, p := range pairs {
+		var (
+			want, wantErr = grid.GetPath(p[0], p[1], p[2], p[3])
+			got, err      = costs.FindPath(p[0], p[1], p[2], p[3], PathOptions{})
+		)
+		if (err != nil) != (wantErr != nil) {
+			t.Errorf("%v,%v to %v,%v: FindPath error %v, GetPath error %v", p[0], p[1], p[2], p[3], err, wantErr)
+			continue
+		}
+		if len(got) != len(want) {
+			t.Errorf("%v,%v to %v,%v: FindPath gave %v cells, GetPath %v", p[0], p[1], p[2], p[3], len(got), len(want))
+		}
+	}
+}
+
+// newTestCosts builds a cost grid from rows of text.  '#' marks a blocked
+// cell, a digit is the cost of entering the cell and anything else costs 1.
+func newTestCosts(rows ...string) *CostGrid {
+	var g = NewCostGrid(int32(len(rows[0])), int32(len(rows)))
+	for y, row := range rows {
+		for x, c := range row {
+			switch {
+			case c == '#':
+				g.Costs[y*len(row)+x] = CostBlocked
+			case c >= '0' && c <= '9':
+				g.Costs[y*len(row)+x] = float32(c - '0')
+			}
+		}
+	}
+	return g
+}
+
+// pathCost adds up what FindPath charges to walk a path.
+func pathCost(g *CostGrid, path []twodee.Point) (cost float32) {
+	for i := 1; i < len(path); i++ {
+		var step = g.Get(int32(path[i].X), int32(path[i].Y))
+		if path[i].X != path[i-1].X && path[i].Y != path[i-1].Y {
+			step *= math.Sqrt2
+		}
+		cost += step
+	}
+	return
+}
+
+// TestFindPath checks the exact path FindPath takes, and what it costs, for
+// each of its options.  A nil path means no path should be found.
+func TestFindPath(t *testing.T) {
+	var tests = []struct {
+		name string
+		rows []string
+		from [2]int32
+		to   [2]int32
+		opts PathOptions
+		path []twodee.Point
+		cost float32
+	}{
+		{
+			name: "weighted",
+			rows: []string{
+				".....",
+				".999.",
+				"22222",
+			},
+			from: [2]int32{0, 1},
+			to:   [2]int32{4, 1},
+			path: []twodee.Point{
+				twodee.Pt(0, 1), twodee.Pt(0, 0), twodee.Pt(1, 0), twodee.Pt(2, 0),
+				twodee.Pt(3, 0), twodee.Pt(4, 0), twodee.Pt(4, 1),
+			},
+			cost: 6,
+		},
+		{
+			name: "diagonal",
+			rows: []string{
+				"....",
+				"....",
+				"....",
+				"....",
+			},
+			from: [2]int32{0, 0},
+			to:   [2]int32{3, 3},
+			opts: PathOptions{Diagonal: true},
+			path: []twodee.Point{twodee.Pt(0, 0), twodee.Pt(1, 1), twodee.Pt(2, 2), twodee.Pt(3, 3)},
+			cost: 3 * math.Sqrt2,
+		},
+		{
+			name: "no corner cutting",
+			rows: []string{
+				".#",
+				"..",
+			},
+			from: [2]int32{0, 0},
+			to:   [2]int32{1, 1},
+			opts: PathOptions{Diagonal: true},
+			path: []twodee.Point{twodee.Pt(0, 0), twodee.Pt(0, 1), twodee.Pt(1, 1)},
+			cost: 2,
+		},
+		{
+			name: "corner cutting",
+			rows: []string{
+				".#",
+				"..",
+			},
+			from: [2]int32{0, 0},
+			to:   [2]int32{1, 1},
+			opts: PathOptions{Diagonal: true, CutCorners: true},
+			path: []twodee.Point{twodee.Pt(0, 0), twodee.Pt(1, 1)},
+			cost: math.Sqrt2,
+		},
+		{
+			name: "expansion cap reached",
+			rows: []string{"........."},
+			from: [2]int32{0, 0},
+			to:   [2]int32{8, 0},
+			opts: PathOptions{MaxExpanded: 7},
+		},
+		{
+			name: "expansion cap not reached",
+			rows: []string{"........."},
+			from: [2]int32{0, 0},
+			to:   [2]int32{8, 0},
+			opts: PathOptions{MaxExpanded: 8},
+			path: []twodee.Point{
+				twodee.Pt(0, 0), twodee.Pt(1, 0), twodee.Pt(2, 0), twodee.Pt(3, 0), twodee.Pt(4, 0),
+				twodee.Pt(5, 0), twodee.Pt(6, 0), twodee.Pt(7, 0), twodee.Pt(8, 0),
+			},
+			cost: 8,
+		},
+	}
+	for _, test := range tests {
+		var (
+			costs     = newTestCosts(test.rows...)
+			path, err = costs.FindPath(test.from[0], test.from[1], test.to[0], test.to[1], test.opts)
+		)
+		if test.path == nil {
+			if err == nil {
+				t.Errorf("%v: found %v, want no path", test.name, path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(path, test.path) {
+			t.Errorf("%v: got path %v, want %v", test.name, path, test.path)
+		}
+		if cost := pathCost(costs, path); math.Abs(float64(cost-test.cost)) > 1e-5 {
+			t.Errorf("%v: got cost %v, want %v", test.name, cost, test.cost)
+		}
+	}
+}
@@ -25,12 +25,14 @@ type DebugLayer struct {
 	camera  *twodee.Camera
 	text    *twodee.TextRenderer
 	fpstext *twodee.TextCache
+	sprtext *twodee.TextCache
 	font    *twodee.FontFace
 	counter *twodee.Counter
+	sprites *SpriteQueue
 	bounds  twodee.Rectangle
 }
 
-func NewDebugLayer(winb twodee.Rectangle, counter *twodee.Counter) (layer *DebugLayer, err error) {
+func NewDebugLayer(winb twodee.Rectangle, counter *twodee.Counter, sprites *SpriteQueue) (layer *DebugLayer, err error) {
 	var (
 		font *twodee.FontFace
 		fg   = color.RGBA{0, 255, 0, 255}
@@ -41,8 +43,10 @@ func NewDebugLayer(winb twodee.Rectangle, counter *twodee.Counter) (layer *Debug
 	}
 	layer = &DebugLayer{
 		fpstext: twodee.NewTextCache(font),
+		sprtext: twodee.NewTextCache(font),
 		font:    font,
 		counter: counter,
+		sprites: sprites,
 		bounds:  winb,
 	}
 	err = layer.Reset()
@@ -61,18 +65,24 @@ func (dl *DebugLayer) Reset() (err error) {
 		return
 	}
 	dl.fpstext.Clear()
+	dl.sprtext.Clear()
 	return
 }
 
 func (dl *DebugLayer) Delete() {
 	dl.text.Delete()
 	dl.fpstext.Delete()
+	dl.sprtext.Delete()
 }
 
 func (dl *DebugLayer) Render() {
 	dl.text.Bind()
 	dl.fpstext.SetText(fmt.Sprintf("%3.3f ms/frame", dl.counter.Avg))
 	dl.text.Draw(dl.fpstext.Texture, 0, 0)
+	var stats = dl.sprites.Stats
+	dl.sprtext.SetText(fmt.Sprintf("%d sprites, %d culled, %d drawn in %d calls",
+		stats.Submitted, stats.Culled, stats.Drawn, stats.DrawCalls))
+	dl.text.Draw(dl.sprtext.Texture, 0, float32(dl.fpstext.Texture.Height))
 	dl.text.Unbind()
 }
 
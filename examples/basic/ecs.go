@@ -0,0 +1,394 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	twodee "../../libs/twodee"
+	"time"
+)
+
+// EntityID names an entity in a World.  Its low bits index the World's
+// stores, and the index of a destroyed entity is given to entities created
+// after it.  Its high bits count how often the index has been reused, so an
+// ID kept after its entity is destroyed does not name the one created in its
+// place.
+type EntityID int32
+
+// NoEntity is the ID of an entity which does not exist.
+const NoEntity EntityID = -1
+
+// entityIndexBits limits how many entities a World holds at once.  The
+// generation wraps within the bits left over.
+const (
+	entityIndexBits = 20
+	entityIndexMask = 1<<entityIndexBits - 1
+	entityGenMask   = 1<<(31-entityIndexBits) - 1
+)
+
+// Index returns where the entity's components are in the World's stores.
+func (id EntityID) Index() int {
+	return int(id & entityIndexMask)
+}
+
+func (id EntityID) generation() int32 {
+	return int32(id) >> entityIndexBits
+}
+
+// ComponentMask is a set of component types.
+type ComponentMask uint8
+
+const (
+	HasTransform ComponentMask = 1 << iota
+	HasVelocity
+	HasSprite
+	HasAnimation
+	HasCollider
+
+	// entityAlive marks IDs in use, so an entity with no components is
+	// still alive.
+	entityAlive ComponentMask = 1 << 7
+)
+
+// Transform places an entity in the world.  Rotation is in radians about the
+// X axis, tipping sprites back into the screen like the swarm's objects have
+// always been drawn.
+type Transform struct {
+	Pos      twodee.Point
+	Rotation float32
+}
+
+// Velocity is how far an entity moves each second, in world units.
+type Velocity struct {
+	X float32
+	Y float32
+}
+
+// Sprite is the frame an entity is drawn with, and the sprite layer it is
+// drawn in.
+type Sprite struct {
+	Layer int
+	Frame FrameHandle
+}
+
+// World holds every entity's components in a store for each type, indexed
+// by EntityID.  Systems walk the stores in order, so updating thousands of
+// entities touches memory sequentially and does not allocate.  Pointers into
+// the stores are only good until the next Create.
+//
+// Previous holds each transform as it was before the last update, so that
+// drawing can interpolate between the last two updates.
+type World struct {
+	Masks      []ComponentMask
+	Transforms []Transform
+	Previous   []Transform
+	Velocities []Velocity
+	Sprites    []Sprite
+	Animations []AnimationPlayer
+	Colliders  []Collider
+	gens       []int32
+	free       []int32
+	count      int
+}
+
+// NewWorld returns a world with room for capacity entities before its
+// stores have to grow.
+func NewWorld(capacity int) *World {
+	return &World{
+		Masks:      make([]ComponentMask, 0, capacity),
+		Transforms: make([]Transform, 0, capacity),
+		Previous:   make([]Transform, 0, capacity),
+		Velocities: make([]Velocity, 0, capacity),
+		Sprites:    make([]Sprite, 0, capacity),
+		Animations: make([]AnimationPlayer, 0, capacity),
+		Colliders:  make([]Collider, 0, capacity),
+		gens:       make([]int32, 0, capacity),
+	}
+}
+
+// Create returns a new entity without any components.
+func (w *World) Create() (id EntityID) {
+	var index int32
+	if n := len(w.free); n > 0 {
+		index = w.free[n-1]
+		w.free = w.free[:n-1]
+	} else {
+		index = int32(len(w.Masks))
+		w.gens = append(w.gens, 0)
+		w.Masks = append(w.Masks, 0)
+		w.Transforms = append(w.Transforms, Transform{})
+		w.Previous = append(w.Previous, Transform{})
+		w.Velocities = append(w.Velocities, Velocity{})
+		w.Sprites = append(w.Sprites, Sprite{})
+		w.Animations = append(w.Animations, AnimationPlayer{})
+		w.Colliders = append(w.Colliders, Collider{})
+	}
+	w.Masks[index] = entityAlive
+	w.count++
+	return EntityID(w.gens[index]<<entityIndexBits | index)
+}
+
+// Destroy removes an entity and all of its components.
+func (w *World) Destroy(id EntityID) {
+	if !w.Alive(id) {
+		return
+	}
+	var i = id.Index()
+	w.Masks[i] = 0
+	w.Transforms[i] = Transform{}
+	w.Previous[i] = Transform{}
+	w.Velocities[i] = Velocity{}
+	w.Sprites[i] = Sprite{}
+	w.Animations[i] = AnimationPlayer{}
+	w.Colliders[i] = Collider{}
+	w.gens[i] = (w.gens[i] + 1) & entityGenMask
+	w.free = append(w.free, int32(i))
+	w.count--
+}
+
+// Len returns how many entities are alive.
+func (w *World) Len() int {
+	return w.count
+}
+
+// Alive reports whether id names an entity which has not been destroyed.
+func (w *World) Alive(id EntityID) bool {
+	var i = id.Index()
+	return id >= 0 && i < len(w.Masks) && w.Masks[i]&entityAlive != 0 &&
+		w.gens[i] == id.generation()
+}
+
+// Has reports whether an entity has every component in mask.
+func (w *World) Has(id EntityID, mask ComponentMask) bool {
+	return w.Alive(id) && w.Masks[id.Index()]&mask == mask
+}
+
+// Remove drops the components in mask from an entity.  Like the Set
+// methods, it ignores entities which are not alive.
+func (w *World) Remove(id EntityID, mask ComponentMask) {
+	if w.Alive(id) {
+		w.Masks[id.Index()] &^= mask &^ entityAlive
+	}
+}
+
+// SetTransform places an entity without interpolating from where it was.
+func (w *World) SetTransform(id EntityID, t Transform) {
+	if w.Alive(id) {
+		var i = id.Index()
+		w.Transforms[i] = t
+		w.Previous[i] = t
+		w.Masks[i] |= HasTransform
+	}
+}
+
+func (w *World) SetVelocity(id EntityID, v Velocity) {
+	if w.Alive(id) {
+		w.Velocities[id.Index()] = v
+		w.Masks[id.Index()] |= HasVelocity
+	}
+}
+
+func (w *World) SetSprite(id EntityID, s Sprite) {
+	if w.Alive(id) {
+		w.Sprites[id.Index()] = s
+		w.Masks[id.Index()] |= HasSprite
+	}
+}
+
+func (w *World) SetAnimation(id EntityID, a AnimationPlayer) {
+	if w.Alive(id) {
+		w.Animations[id.Index()] = a
+		w.Masks[id.Index()] |= HasAnimation
+	}
+}
+
+func (w *World) SetCollider(id EntityID, c Collider) {
+	if w.Alive(id) {
+		w.Colliders[id.Index()] = c
+		w.Masks[id.Index()] |= HasCollider
+	}
+}
+
+// SavePrevious records every transform before an update changes them.
+func (w *World) SavePrevious() {
+	copy(w.Previous, w.Transforms)
+}
+
+// Interpolated returns an entity's transform alpha of the way from where it
+// was before the last update, at 0, to where it is now, at 1.
+func (w *World) Interpolated(id EntityID, alpha float32) Transform {
+	return w.interpolated(id.Index(), alpha)
+}
+
+func (w *World) interpolated(i int, alpha float32) Transform {
+	var (
+		from = &w.Previous[i]
+		to   = &w.Transforms[i]
+	)
+	return Transform{
+		Pos: twodee.Pt(
+			from.Pos.X+(to.Pos.X-from.Pos.X)*alpha,
+			from.Pos.Y+(to.Pos.Y-from.Pos.Y)*alpha,
+		),
+		Rotation: from.Rotation + (to.Rotation-from.Rotation)*alpha,
+	}
+}
+
+// Ref returns a twodee.Entity for an entity of the world.
+func (w *World) Ref(id EntityID) *EntityRef {
+	return &EntityRef{World: w, ID: id}
+}
+
+// EntityRef is an entity of a World as a twodee.Entity, for the code which
+// deals with one entity at a time, like cameras, triggers and scripts.
+type EntityRef struct {
+	World *World
+	ID    EntityID
+}
+
+func (e *EntityRef) Pos() twodee.Point {
+	return e.World.Transforms[e.ID.Index()].Pos
+}
+
+// MoveTo places the entity at a point without interpolating from where it
+// was.
+func (e *EntityRef) MoveTo(pt twodee.Point) {
+	e.World.Transforms[e.ID.Index()].Pos = pt
+	e.World.Previous[e.ID.Index()].Pos = pt
+}
+
+// MoveToCoords is MoveTo for scripts.
+func (e *EntityRef) MoveToCoords(x, y float32) {
+	e.MoveTo(twodee.Pt(x, y))
+}
+
+// Bounds returns the entity's collider around its position, or just its
+// position if it has no collider.
+func (e *EntityRef) Bounds() twodee.Rectangle {
+	var pos = e.Pos()
+	if e.World.Has(e.ID, HasCollider) {
+		return e.World.Colliders[e.ID.Index()].Bounds(pos)
+	}
+	return twodee.Rect(pos.X, pos.Y, pos.X, pos.Y)
+}
+
+// Frame returns the index of the animation frame being shown.
+func (e *EntityRef) Frame() int {
+	return e.World.Animations[e.ID.Index()].Frame()
+}
+
+// Update does nothing, since entities in a World are updated by its systems.
+func (e *EntityRef) Update(elapsed time.Duration) {
+}
+
+// Animation returns the entity's animation component.
+func (e *EntityRef) Animation() *AnimationPlayer {
+	return &e.World.Animations[e.ID.Index()]
+}
+
+// System updates the components of the entities it works on.
+type System interface {
+	Update(w *World, elapsed time.Duration)
+}
+
+// Systems run one after another, in order.
+type Systems []System
+
+// Update saves the world's transforms, to interpolate from, and runs the
+// systems.
+func (s Systems) Update(w *World, elapsed time.Duration) {
+	w.SavePrevious()
+	for _, system := range s {
+		system.Update(w, elapsed)
+	}
+}
+
+// MovementSystem moves entities by their velocity.  Entities with colliders
+// slide along the walls of Grid.
+type MovementSystem struct {
+	Grid *CollisionGrid
+}
+
+func (m *MovementSystem) Update(w *World, elapsed time.Duration) {
+	var dt = float32(elapsed.Seconds())
+	for i, mask := range w.Masks {
+		if mask&(HasTransform|HasVelocity) != HasTransform|HasVelocity {
+			continue
+		}
+		var (
+			v  = w.Velocities[i]
+			t  = &w.Transforms[i]
+			dx = v.X * dt
+			dy = v.Y * dt
+		)
+		if dx == 0 && dy == 0 {
+			continue
+		}
+		if mask&HasCollider != 0 && m.Grid != nil {
+			t.Pos = w.Colliders[i].Move(m.Grid, t.Pos, dx, dy)
+		} else {
+			t.Pos = twodee.Pt(t.Pos.X+dx, t.Pos.Y+dy)
+		}
+	}
+}
+
+// FollowSystem keeps an entity at an offset from the one it trails.
+type FollowSystem struct {
+	Entity EntityID
+	Target EntityID
+	Offset twodee.Point
+}
+
+func (f FollowSystem) Update(w *World, elapsed time.Duration) {
+	var pos = w.Transforms[f.Target.Index()].Pos
+	w.Transforms[f.Entity.Index()].Pos = twodee.Pt(pos.X+f.Offset.X, pos.Y+f.Offset.Y)
+}
+
+// AnimationSystem advances animations, and shows their current frame on
+// entities with sprites.
+type AnimationSystem struct{}
+
+func (AnimationSystem) Update(w *World, elapsed time.Duration) {
+	for i, mask := range w.Masks {
+		if mask&HasAnimation == 0 {
+			continue
+		}
+		var a = &w.Animations[i]
+		a.Update(elapsed)
+		if mask&HasSprite != 0 {
+			if frame := a.Frame(); frame < len(a.Frames) {
+				w.Sprites[i].Frame = a.Frames[frame]
+			}
+		}
+	}
+}
+
+// PushSprites queues every entity with a transform and sprite to be drawn,
+// alpha of the way between its last two transforms.
+func PushSprites(w *World, queue *SpriteQueue, alpha float32) {
+	for i, mask := range w.Masks {
+		if mask&(HasTransform|HasSprite) != HasTransform|HasSprite {
+			continue
+		}
+		var (
+			t = w.interpolated(i, alpha)
+			s = &w.Sprites[i]
+		)
+		queue.Push(s.Layer, s.Frame, twodee.ModelViewConfig{
+			t.Pos.X, t.Pos.Y, 0,
+			t.Rotation, 0, 0,
+			1.0, 1.0, 1.0,
+		})
+	}
+}
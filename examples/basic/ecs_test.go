@@ -0,0 +1,226 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	twodee "../../libs/twodee"
+	"github.com/go-gl/mathgl/mgl32"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// How many entities the benchmarks update and draw, each within a frame at
+// 60Hz, and how many updates they run before the swarm turns toward another
+// goal, as it would while the player moves around.
+const (
+	benchmarkEntities    = 10000
+	benchmarkGoalUpdates = 600
+)
+
+// spawnSwarm spawns count swarm members with every kind of component: along
+// with the transform and velocity the swarm gives them, each has a sprite, a
+// collider and an animation cycling through frames.
+func spawnSwarm(grid *CollisionGrid, costs *CostGrid, count int, frames []FrameHandle) (world *World, swarm *Swarm, systems Systems) {
+	var animation = &Animation{Name: "cycle", Direction: AnimationForward}
+	for i := range frames {
+		animation.Frames = append(animation.Frames, i)
+		animation.Durations = append(animation.Durations, twodee.Step10Hz)
+	}
+	world = NewWorld(count)
+	swarm = NewSwarm(grid, costs, PathOptions{Diagonal: true})
+	swarm.Speed = SwarmSpeed
+	swarm.Spawn(world, count, rand.New(rand.NewSource(1)))
+	for i, id := range swarm.Members {
+		var player = AnimationPlayer{
+			Animations: Animations{animation.Name: animation},
+			Frames:     frames,
+		}
+		player.Play(animation.Name)
+		world.Transforms[id.Index()].Rotation = mgl32.DegToRad(float32(i * 15))
+		world.SetSprite(id, Sprite{Layer: SpriteLayerSwarm, Frame: frames[i%len(frames)]})
+		world.SetAnimation(id, player)
+		world.SetCollider(id, Collider{Width: PlayerSize, Height: PlayerSize})
+	}
+	systems = Systems{swarm, &MovementSystem{Grid: grid}, AnimationSystem{}}
+	return
+}
+
+// noFrames returns handles for an animation of NumberedFrames frames which
+// draws nothing, for entities which are only updated.
+func noFrames() (frames []FrameHandle) {
+	frames = make([]FrameHandle, NumberedFrames)
+	for i := range frames {
+		frames[i] = NoFrame
+	}
+	return
+}
+
+// TestSystemsUpdateAllocs checks that updating the swarm's systems does not
+// allocate, even when the goal moves and the flow field is recomputed.
+func TestSystemsUpdateAllocs(t *testing.T) {
+	const size = 64
+	var walls = twodee.NewGrid(size, size)
+	for i := int32(0); i < size; i++ {
+		walls.Set(i, 0, true)
+		walls.Set(i, size-1, true)
+		walls.Set(0, i, true)
+		walls.Set(size-1, i, true)
+	}
+	var (
+		grid                  = NewCollisionGrid(walls, 1, 1)
+		world, swarm, systems = spawnSwarm(grid, NewCostGridFromGrid(walls), 1000, noFrames())
+		goals                 = [2]twodee.Point{
+			grid.GridToWorld(1, 1),
+			grid.GridToWorld(size-2, size-2),
+		}
+		updates int
+	)
+	update := func() {
+		updates++
+		swarm.SetGoal(goals[updates%2])
+		systems.Update(world, twodee.Step60Hz)
+	}
+	if allocs := testing.AllocsPerRun(100, update); allocs > 0 {
+		t.Fatalf("Systems allocated %v times per update", allocs)
+	}
+}
+
+// TestWorldStaleID checks that the ID of a destroyed entity does not name
+// the entity created in its place, and that changing it does nothing.
+func TestWorldStaleID(t *testing.T) {
+	var (
+		w     = NewWorld(1)
+		stale = w.Create()
+	)
+	w.Destroy(stale)
+	var id = w.Create()
+	if id.Index() != stale.Index() {
+		t.Fatalf("Create used index %v, not the freed %v", id.Index(), stale.Index())
+	}
+	if id == stale || w.Alive(stale) || !w.Alive(id) {
+		t.Fatalf("Stale %v alive %v, new %v alive %v", stale, w.Alive(stale), id, w.Alive(id))
+	}
+	w.SetVelocity(id, Velocity{1, 2})
+	w.SetTransform(stale, Transform{Pos: twodee.Pt(3, 4)})
+	w.SetVelocity(stale, Velocity{5, 6})
+	w.Remove(stale, HasVelocity)
+	w.Destroy(stale)
+	if w.Len() != 1 || w.Has(id, HasTransform) || !w.Has(id, HasVelocity) {
+		t.Fatalf("Stale ID changed the new entity: mask %v", w.Masks[id.Index()])
+	}
+	if v := w.Velocities[id.Index()]; v != (Velocity{1, 2}) {
+		t.Fatalf("Stale ID changed the velocity to %v", v)
+	}
+}
+
+// loadSwarm spawns benchmarkEntities swarm members on the benchmark level.
+func loadSwarm(b *testing.B, frames []FrameHandle) (world *World, swarm *Swarm, systems Systems) {
+	var level, err = GetLevel("benchmark", benchmarkLevel, benchmarkSeed)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return spawnSwarm(level.Collision, level.Costs, benchmarkEntities, frames)
+}
+
+// reportFrames reports how much of a 60Hz frame the benchmark's operations
+// since start took on average, which must stay below 1.  It is reported
+// rather than checked, since timings vary too much between machines to fail
+// on.
+func reportFrames(b *testing.B, start time.Time) {
+	var op = time.Since(start) / time.Duration(b.N)
+	b.ReportMetric(float64(op)/float64(twodee.Step60Hz), "frames/op")
+}
+
+// BenchmarkEntitiesUpdate measures one update of 10000 entities chasing a
+// goal.  The goal moves every so often, between where two of the entities
+// started, so the flow field is recomputed as it would be in play.
+func BenchmarkEntitiesUpdate(b *testing.B) {
+	var (
+		world, swarm, systems = loadSwarm(b, noFrames())
+		goals                 = [2]twodee.Point{
+			world.Transforms[swarm.Members[0].Index()].Pos,
+			world.Transforms[swarm.Members[1].Index()].Pos,
+		}
+	)
+	swarm.SetGoal(goals[0])
+	b.ReportAllocs()
+	b.ResetTimer()
+	var start = time.Now()
+	for i := 0; i < b.N; i++ {
+		if i%benchmarkGoalUpdates == 0 {
+			swarm.SetGoal(goals[(i/benchmarkGoalUpdates+1)%2])
+		}
+		systems.Update(world, twodee.Step60Hz)
+	}
+	reportFrames(b, start)
+}
+
+// BenchmarkEntitiesDraw measures queueing, culling, sorting and drawing a
+// frame of 10000 entities through the game's TextureDrawer, reporting any
+// allocations along with the time.
+func BenchmarkEntitiesDraw(b *testing.B) {
+	requireWindow(b)
+	var (
+		camera   *twodee.Camera
+		renderer *twodee.SpriteRenderer
+		sheet    *Spritesheet
+		queue    *SpriteQueue
+		frames   GameFrames
+		err      error
+	)
+	if camera, err = twodee.NewCamera(twodee.Rect(0, 0, benchmarkViewSize, benchmarkViewSize), twodee.Rect(0, 0, benchmarkWindowSize, benchmarkWindowSize)); err != nil {
+		b.Fatal(err)
+	}
+	if renderer, err = twodee.NewSpriteRenderer(camera); err != nil {
+		b.Fatal(err)
+	}
+	defer renderer.Delete()
+	if sheet, err = GetSpritesheet(GameSpritesheet); err != nil {
+		b.Fatal(err)
+	}
+	defer sheet.Delete()
+	queue = NewSpriteQueue(TextureDrawer{renderer})
+	if frames, err = GetGameFrames(queue, sheet, nil); err != nil {
+		b.Fatal(err)
+	}
+	var (
+		world, swarm, _ = loadSwarm(b, frames.Numbered[:])
+		center          = world.Transforms[swarm.Members[0].Index()].Pos
+		view            = twodee.Rect(
+			center.X-benchmarkViewSize/2,
+			center.Y-benchmarkViewSize/2,
+			center.X+benchmarkViewSize/2,
+			center.Y+benchmarkViewSize/2,
+		)
+	)
+	camera.SetWorldBounds(view)
+	draw := func() {
+		queue.Begin(view, Roll{})
+		PushSprites(world, queue, 1)
+		if err := queue.Flush(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	// Grow the queue's buffers before timing.
+	draw()
+	b.ReportAllocs()
+	b.ResetTimer()
+	var start = time.Now()
+	for i := 0; i < b.N; i++ {
+		draw()
+	}
+	reportFrames(b, start)
+}
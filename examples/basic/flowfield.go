@@ -0,0 +1,226 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	twodee "../../libs/twodee"
+	"math"
+)
+
+// Unreachable is the distance of cells which cannot reach the goal.
+var Unreachable = float32(math.Inf(1))
+
+// flowDirs lists the moves a flow field may point along.  The first four are
+// orthogonal; a direction of -1 means the cell has nowhere to go.
+var flowDirs = [][2]int32{
+	{1, 0}, {-1, 0}, {0, 1}, {0, -1},
+	{1, 1}, {1, -1}, {-1, 1}, {-1, -1},
+}
+
+// FlowField is a Dijkstra map over a CostGrid: the cost of the cheapest path
+// from every cell to a single goal, plus the direction to step from each cell.
+// It is computed once per goal, after which any number of agents can look up
+// their direction in constant time instead of each running a path search.
+type FlowField struct {
+	Costs   *CostGrid
+	Options PathOptions
+	Dist    []float32
+	Dirs    []int8
+	goal    int32
+	queue   flowHeap
+}
+
+func NewFlowField(costs *CostGrid, opts PathOptions) *FlowField {
+	return &FlowField{
+		Costs:   costs,
+		Options: opts,
+		Dist:    make([]float32, len(costs.Costs)),
+		Dirs:    make([]int8, len(costs.Costs)),
+		goal:    -1,
+	}
+}
+
+// SetGoal recomputes the field for a goal cell, unless the goal is unchanged.
+// Returns false if the goal is blocked or outside the grid.
+func (f *FlowField) SetGoal(x, y int32) bool {
+	if !f.Costs.Passable(x, y) {
+		return false
+	}
+	var goal = y*f.Costs.Width + x
+	if goal == f.goal {
+		return true
+	}
+	f.goal = goal
+	f.compute()
+	return true
+}
+
+// Goal returns the current goal cell, or ok == false if none has been set.
+func (f *FlowField) Goal() (x, y int32, ok bool) {
+	if f.goal < 0 {
+		return
+	}
+	return f.goal % f.Costs.Width, f.goal / f.Costs.Width, true
+}
+
+// Reset forgets the goal, for example after the costs have changed.
+func (f *FlowField) Reset() {
+	f.goal = -1
+}
+
+func (f *FlowField) moves() int {
+	if f.Options.Diagonal {
+		return len(flowDirs)
+	}
+	return 4
+}
+
+// canStep reports whether an agent may step from (x, y) along a direction.
+func (f *FlowField) canStep(x, y int32, d [2]int32) bool {
+	if !f.Costs.Passable(x+d[0], y+d[1]) {
+		return false
+	}
+	if d[0] != 0 && d[1] != 0 && !f.Options.CutCorners {
+		return f.Costs.Passable(x+d[0], y) && f.Costs.Passable(x, y+d[1])
+	}
+	return true
+}
+
+func (f *FlowField) compute() {
+	var (
+		w     = f.Costs.Width
+		moves = f.moves()
+	)
+	for i := range f.Dist {
+		f.Dist[i] = Unreachable
+		f.Dirs[i] = -1
+	}
+	f.Dist[f.goal] = 0
+	f.queue = f.queue[:0]
+	f.queue.push(flowItem{f.goal, 0})
+	// Search outward from the goal.  Stepping from a neighbor onto the
+	// current cell costs the current cell's cost, as in CostGrid.FindPath.
+	for len(f.queue) > 0 {
+		var item = f.queue.pop()
+		if item.dist > f.Dist[item.index] {
+			continue
+		}
+		var (
+			x    = item.index % w
+			y    = item.index / w
+			cost = f.Costs.Costs[item.index]
+		)
+		for i := 0; i < moves; i++ {
+			var (
+				d      = flowDirs[i]
+				nx, ny = x - d[0], y - d[1]
+			)
+			if !f.Costs.Passable(nx, ny) || !f.canStep(nx, ny, d) {
+				continue
+			}
+			var step = cost
+			if i >= 4 {
+				step *= math.Sqrt2
+			}
+			var n = ny*w + nx
+			if dist := item.dist + step; dist < f.Dist[n] {
+				f.Dist[n] = dist
+				f.Dirs[n] = int8(i)
+				f.queue.push(flowItem{n, dist})
+			}
+		}
+	}
+}
+
+// Direction returns the grid step to take from a cell, with row 0 at the top
+// of the map.  ok is false for the goal and for cells which cannot reach it.
+func (f *FlowField) Direction(x, y int32) (dx, dy int32, ok bool) {
+	if x < 0 || y < 0 || x >= f.Costs.Width || y >= f.Costs.Height {
+		return
+	}
+	var dir = f.Dirs[y*f.Costs.Width+x]
+	if dir < 0 {
+		return
+	}
+	return flowDirs[dir][0], flowDirs[dir][1], true
+}
+
+// Sample returns the world space point an agent at pt should head for next:
+// the center of the neighboring cell the field points to, or the goal cell's
+// center once the agent has arrived.  ok is false if the goal cannot be
+// reached from pt.
+func (f *FlowField) Sample(grid *CollisionGrid, pt twodee.Point) (next twodee.Point, ok bool) {
+	var x, y = grid.WorldToGrid(pt)
+	if gx, gy, set := f.Goal(); set && x == gx && y == gy {
+		return grid.GridToWorld(x, y), true
+	}
+	dx, dy, ok := f.Direction(x, y)
+	if !ok {
+		return
+	}
+	return grid.GridToWorld(x+dx, y+dy), true
+}
+
+type flowItem struct {
+	index int32
+	dist  float32
+}
+
+// flowHeap is a binary min-heap of cells by distance.  It is written out
+// rather than using container/heap to avoid boxing every item.
+type flowHeap []flowItem
+
+func (h *flowHeap) push(item flowItem) {
+	*h = append(*h, item)
+	var q = *h
+	for i := len(q) - 1; i > 0; {
+		var parent = (i - 1) / 2
+		if q[parent].dist <= q[i].dist {
+			break
+		}
+		q[parent], q[i] = q[i], q[parent]
+		i = parent
+	}
+}
+
+func (h *flowHeap) pop() flowItem {
+	var (
+		q    = *h
+		top  = q[0]
+		last = len(q) - 1
+	)
+	q[0] = q[last]
+	q = q[:last]
+	for i := 0; ; {
+		var (
+			left     = 2*i + 1
+			right    = left + 1
+			smallest = i
+		)
+		if left < len(q) && q[left].dist < q[smallest].dist {
+			smallest = left
+		}
+		if right < len(q) && q[right].dist < q[smallest].dist {
+			smallest = right
+		}
+		if smallest == i {
+			break
+		}
+		q[i], q[smallest] = q[smallest], q[i]
+		i = smallest
+	}
+	*h = q
+	return top
+}
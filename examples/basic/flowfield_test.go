@@ -0,0 +1,170 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	twodee "../../libs/twodee"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// TestFlowField checks a field over a small walled grid against FindPath.
+// Following the field from every cell which can reach the goal must get
+// there for what FindPath charges, and every other cell has no direction.
+func TestFlowField(t *testing.T) {
+	var costs = newTestCosts(
+		"#########",
+		"#..3....#",
+		"#.##.#..#",
+		"#.5#.#9.#",
+		"#..#..#.#",
+		"######..#",
+		"#..#....#",
+		"#.##.####",
+		"#.#..#..#",
+		"#########",
+	)
+	const goalX, goalY = 4, 8
+	for _, opts := range []PathOptions{{}, {Diagonal: true}} {
+		var field = NewFlowField(costs, opts)
+		if !field.SetGoal(goalX, goalY) {
+			t.Fatalf("Goal %v,%v is blocked", goalX, goalY)
+		}
+		for y := int32(0); y < costs.Height; y++ {
+			for x := int32(0); x < costs.Width; x++ {
+				if !costs.Passable(x, y) || (x == goalX && y == goalY) {
+					continue
+				}
+				var (
+					dist      = field.Dist[y*costs.Width+x]
+					path, err = costs.FindPath(x, y, goalX, goalY, opts)
+				)
+				if err != nil {
+					if _, _, ok := field.Direction(x, y); ok || dist != Unreachable {
+						t.Errorf("%+v: %v,%v cannot reach the goal, but has distance %v", opts, x, y, dist)
+					}
+					continue
+				}
+				if want := pathCost(costs, path); math.Abs(float64(dist-want)) > 1e-4 {
+					t.Errorf("%+v: %v,%v has distance %v, FindPath cost %v", opts, x, y, dist, want)
+				}
+				var (
+					route = []twodee.Point{twodee.Pt(float32(x), float32(y))}
+					cx    = x
+					cy    = y
+				)
+				for len(route) <= len(costs.Costs) && (cx != goalX || cy != goalY) {
+					dx, dy, ok := field.Direction(cx, cy)
+					if !ok {
+						break
+					}
+					cx, cy = cx+dx, cy+dy
+					route = append(route, twodee.Pt(float32(cx), float32(cy)))
+				}
+				if cx != goalX || cy != goalY {
+					t.Errorf("%+v: following the field from %v,%v stopped at %v,%v", opts, x, y, cx, cy)
+				} else if cost := pathCost(costs, route); math.Abs(float64(cost-dist)) > 1e-4 {
+					t.Errorf("%+v: following the field from %v,%v cost %v, not %v", opts, x, y, cost, dist)
+				}
+			}
+		}
+	}
+}
+
+// flowAgents is how many agents the flow field benchmarks steer, which
+// matches the largest setting of the Objects menu.
+const flowAgents = 4096
+
+// loadFlowLevel loads the benchmark level and picks two passable cells far
+// apart, for the benchmarks to head toward.
+func loadFlowLevel(b *testing.B) (level *Level, goals [2]twodee.Point) {
+	var err error
+	if level, err = GetLevel("benchmark", benchmarkLevel, benchmarkSeed); err != nil {
+		b.Fatal(err)
+	}
+	var (
+		costs = level.Costs
+		first = int32(-1)
+		last  = int32(-1)
+	)
+	for i, c := range costs.Costs {
+		if c >= 0 {
+			if first < 0 {
+				first = int32(i)
+			}
+			last = int32(i)
+		}
+	}
+	goals[0] = level.Collision.GridToWorld(first%costs.Width, first/costs.Width)
+	goals[1] = level.Collision.GridToWorld(last%costs.Width, last/costs.Width)
+	return
+}
+
+// BenchmarkFlowFieldCompute measures recomputing the field for a new goal.
+func BenchmarkFlowFieldCompute(b *testing.B) {
+	var (
+		level, goals = loadFlowLevel(b)
+		field        = NewFlowField(level.Costs, PathOptions{Diagonal: true})
+		cells        [2][2]int32
+	)
+	for i, goal := range goals {
+		cells[i][0], cells[i][1] = level.Collision.WorldToGrid(goal)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		field.SetGoal(cells[i%2][0], cells[i%2][1])
+	}
+}
+
+// BenchmarkFlowFieldSwarm measures one tick of 4096 agents following a field
+// which has already been computed.
+func BenchmarkFlowFieldSwarm(b *testing.B) {
+	var (
+		level, goals = loadFlowLevel(b)
+		world        = NewWorld(flowAgents)
+		swarm        = NewSwarm(level.Collision, level.Costs, PathOptions{Diagonal: true})
+		movement     = &MovementSystem{Grid: level.Collision}
+	)
+	swarm.Speed = SwarmSpeed
+	swarm.Spawn(world, flowAgents, rand.New(rand.NewSource(1)))
+	swarm.SetGoal(goals[0])
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		swarm.Update(world, twodee.Step60Hz)
+		movement.Update(world, twodee.Step60Hz)
+	}
+}
+
+// BenchmarkFindPathAgents measures the approach the flow field replaces:
+// one A* search per agent toward the same goal.
+func BenchmarkFindPathAgents(b *testing.B) {
+	var (
+		level, goals = loadFlowLevel(b)
+		world        = NewWorld(flowAgents)
+		opts         = PathOptions{Diagonal: true}
+		swarm        = NewSwarm(level.Collision, level.Costs, opts)
+	)
+	swarm.Spawn(world, flowAgents, rand.New(rand.NewSource(1)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, id := range swarm.Members {
+			level.Collision.GetPath(world.Transforms[id.Index()].Pos, goals[0], level.Costs, opts)
+		}
+	}
+}
@@ -11,6 +11,11 @@ const (
 	MenuMusic
 	PauseMusic
 	ResumeMusic
+	LoadLevel
+	TriggerEnter
+	TriggerLeave
+	Shake
+	AnimationFrame
 	SENTINEL
 )
 
@@ -16,146 +16,540 @@ package main
 
 import (
 	twodee "../../libs/twodee"
+	tiled "../tiled"
 	"fmt"
 	"github.com/go-gl/mathgl/mgl32"
 	"github.com/kurrik/tmxgo"
 	"image/color"
-	"io/ioutil"
+	"math"
+	"math/rand"
+	"path/filepath"
 	"time"
 )
 
+const (
+	// Width and height of the player's collision box in world units.  This is
+	// smaller than a tile so the player fits down any path the grid allows.
+	PlayerSize float32 = 0.45
+
+	// Most cells a click-to-move search may expand before giving up.
+	PathMaxExpanded = 20000
+
+	// World units per second the objects chasing the player move.
+	SwarmSpeed float32 = 3
+
+	// How the camera follows the player: the size of the box the player
+	// can move in without moving the camera, how quickly it catches up and
+	// how many seconds of movement it leads the player by.
+	CameraDeadzoneWidth  float32 = 4
+	CameraDeadzoneHeight float32 = 3
+	CameraSmoothing      float32 = 5
+	CameraLookAhead      float32 = 0.25
+
+	// Zoom limits, how much one step of the mouse wheel zooms by, and how
+	// quickly zooming animates.
+	CameraMinZoom       float32 = 0.5
+	CameraMaxZoom       float32 = 4
+	CameraZoomStep      float64 = 1.25
+	CameraZoomSmoothing float32 = 12
+
+	// Window pixels per world unit in every viewport at a zoom of 1, and
+	// the zoom of the picture in picture overview.
+	ViewPxPerUnit    float32 = 32
+	ViewOverviewZoom float32 = 0.25
+
+	// Screen shake at full trauma, how fast it jitters and how much trauma
+	// wears off each second.
+	ShakeMaxOffset float32 = 0.6
+	ShakeMaxRoll   float32 = 0.1
+	ShakeFrequency float32 = 15
+	ShakeDecay     float32 = 0.8
+)
+
 type GameLayer struct {
-	shake        *twodee.ContinuousAnimation
-	cameraBounds twodee.Rectangle
-	camera       *twodee.Camera
-	batch        *twodee.BatchRenderer
-	glow         *twodee.GlowRenderer
-	sprite       *twodee.SpriteRenderer
-	lines        *twodee.LinesRenderer
-	mousex       float32
-	mousey       float32
-	player       twodee.Entity
-	state        *State
-	level        *twodee.Batch
-	app          *Application
-	script       *twodee.Scripting
-	sheet        *twodee.Spritesheet
-	sheetTexture *twodee.Texture
-	lineSegments []mgl32.Vec2
-}
-
-func WriteGrid(m *tmxgo.Map) (err error) {
+	shake       *ScreenShake
+	roll        float32
+	rollPrev    float32
+	rollDrawn   float32
+	camera      *twodee.Camera
+	screen      twodee.Rectangle
+	viewports   []*Viewport
+	viewMode    ViewMode
+	batch       *twodee.BatchRenderer
+	glow        *twodee.GlowRenderer
+	sprite      *twodee.SpriteRenderer
+	sprites     *SpriteQueue
+	lines       *twodee.LinesRenderer
+	pathLine    []mgl32.Vec2
+	mousex      float32
+	mousey      float32
+	world       *World
+	systems     Systems
+	movement    *MovementSystem
+	player      *EntityRef
+	playerAnim  *AnimationController
+	playerPath  *PathFollower
+	tall        EntityID
+	wide        EntityID
+	entities    []*LevelEntity
+	swarm       *Swarm
+	registry    *EntityRegistry
+	triggers    *TriggerSystem
+	state       *State
+	levels      *LevelManager
+	app         *Application
+	script      *twodee.Scripting
+	sheet       *Spritesheet
+	playerSheet *Spritesheet
+	frames      GameFrames
+	glowSprites []twodee.SpriteConfig
+
+	loadLevelObserverId    int
+	triggerEnterObserverId int
+	shakeObserverId        int
+}
+
+// GameSpritesheet is the sheet the game layer draws sprites from, and
+// PlayerSpritesheet is the Aseprite sheet of the player's animations.
+const (
+	GameSpritesheet   = "assets/textures/spritesheet.json"
+	PlayerSpritesheet = "assets/textures/player.json"
+)
+
+// Names of the player's animation states, which play the animations of the
+// same name tagged in its Aseprite file.
+const (
+	PlayerIdle      = "idle"
+	PlayerWalkLeft  = "walk_left"
+	PlayerWalkRight = "walk_right"
+	PlayerHurt      = "hurt"
+)
+
+// Speeds, in world units per second, below which the player is idle, and
+// above which it faces the way it is moving sideways.
+const (
+	PlayerIdleSpeed float32 = 0.1
+	PlayerTurnSpeed float32 = 0.5
+)
+
+// NumberedFrames is how many numbered square frames the spritesheet has.
+const NumberedFrames = 16
+
+// GameFrames holds the handles of the frames the game layer draws, resolved
+// whenever the spritesheet is loaded.
+type GameFrames struct {
+	Numbered [NumberedFrames]FrameHandle
+	Tall     FrameHandle
+	Wide     FrameHandle
+
+	// Player holds every frame of the player's sheet, in order, since its
+	// animations refer to frames by index.
+	Player []FrameHandle
+}
+
+// GetGameFrames registers the game layer's frames with a sprite queue.
+// player may be nil, for drawing without the player.
+func GetGameFrames(queue *SpriteQueue, sheet, player *Spritesheet) (frames GameFrames, err error) {
+	for i := range frames.Numbered {
+		var name = fmt.Sprintf("numbered_squares_%02d", i+1)
+		if frames.Numbered[i] = queue.FrameByName(sheet, name); frames.Numbered[i] == NoFrame {
+			err = fmt.Errorf("Spritesheet has no frame %v", name)
+			return
+		}
+	}
+	if frames.Tall = queue.FrameByName(sheet, "numbered_squares_tall_07"); frames.Tall == NoFrame {
+		err = fmt.Errorf("Spritesheet has no frame numbered_squares_tall_07")
+		return
+	}
+	if frames.Wide = queue.FrameByName(sheet, "numbered_squares_wide_14"); frames.Wide == NoFrame {
+		err = fmt.Errorf("Spritesheet has no frame numbered_squares_wide_14")
+		return
+	}
+	if player != nil {
+		frames.Player = make([]FrameHandle, len(player.Names))
+		for i, name := range player.Names {
+			frames.Player[i] = queue.FrameByName(player, name)
+		}
+	}
+	return
+}
+
+// NewPlayerAnimation sets up the player's animation states: idle when still,
+// walking left or right when moving, and a one shot flinch when hurt.  Each
+// step of the walk raises a footstep event.
+func NewPlayerAnimation(player *EntityRef, events *twodee.GameEventHandler) (c *AnimationController) {
 	var (
-		grid  *twodee.Grid
-		tiles []*tmxgo.Tile
-		path  []twodee.Point
+		footsteps = map[int]string{0: FootstepEvent, 4: FootstepEvent}
+		still     = func(v twodee.Point) bool {
+			return math.Hypot(float64(v.X), float64(v.Y)) < float64(PlayerIdleSpeed)
+		}
 	)
-	if tiles, err = m.TilesFromLayerName("collision"); err != nil {
+	c = NewAnimationController(player)
+	c.AddState(&AnimationState{Name: PlayerIdle, Animation: PlayerIdle})
+	c.AddState(&AnimationState{Name: PlayerWalkLeft, Animation: PlayerWalkLeft, Events: footsteps})
+	c.AddState(&AnimationState{Name: PlayerWalkRight, Animation: PlayerWalkRight, Events: footsteps})
+	c.AddState(&AnimationState{Name: PlayerHurt, Animation: PlayerHurt, OneShot: true})
+	c.AddTransition("", PlayerIdle, still)
+	c.AddTransition("", PlayerWalkLeft, func(v twodee.Point) bool {
+		return v.X < -PlayerTurnSpeed
+	})
+	c.AddTransition("", PlayerWalkRight, func(v twodee.Point) bool {
+		return v.X > PlayerTurnSpeed
+	})
+	// Moving straight up or down keeps whichever way the player faced, or
+	// faces right from a standstill.
+	c.AddTransition(PlayerIdle, PlayerWalkRight, func(v twodee.Point) bool {
+		return !still(v)
+	})
+	c.OnEvent = func(state *AnimationState, event string) {
+		events.Enqueue(NewAnimationEvent(player, state.Name, event))
+	}
+	return
+}
+
+// GetCollisionGrid marks every cell of the "collision" layer which has a tile.
+func GetCollisionGrid(m *tmxgo.Map) (grid *twodee.Grid, err error) {
+	var cells []bool
+	if cells, err = tiled.LayerCells(m, "collision"); err != nil {
 		return
 	}
 	grid = twodee.NewGrid(m.Width, m.Height)
-	for i, t := range tiles {
-		if t != nil {
+	for i, blocked := range cells {
+		if blocked {
 			grid.SetIndex(int32(i), true)
 		}
 	}
-	img := grid.GetImage(color.RGBA{0, 0, 255, 255}, color.RGBA{0, 0, 0, 255})
-	if path, err = grid.GetPath(0, 0, 50, 50); err != nil {
+	return
+}
+
+// GetCostGrid reads movement costs from the "cost" properties of the tiles
+// in the "ground" layer, if there is one, and blocks every collision cell.
+func GetCostGrid(doc *tiled.TmxDocument, grid *twodee.Grid) (costs *CostGrid, err error) {
+	if layer, _ := doc.Layer("ground"); layer == nil {
+		costs = NewCostGridFromGrid(grid)
 		return
 	}
-	for _, pt := range path {
-		img.Set(int(pt.X), int(pt.Y), color.RGBA{255, 0, 0, 128})
+	if costs, err = NewCostGridFromTileProperties(doc, "ground"); err != nil {
+		return
 	}
-	err = twodee.WritePNG("collision.png", img)
+	costs.Block(grid)
 	return
 }
 
-func GetLevel() (out *twodee.Batch, err error) {
+// GetLevel loads the level in dir, automapping it with seed as described by
+// tiled.LoadMap.  Its layers are not drawable until LoadLayers is called.
+func GetLevel(name, dir string, seed int64) (level *Level, err error) {
 	var (
-		data     []byte
-		m        *tmxgo.Map
-		tiles    []*tmxgo.Tile
-		textiles []twodee.TexturedTile
-		path     string
+		doc     *tiled.TmxDocument
+		m       *tmxgo.Map
+		objects []*tiled.TmxObject
+		grid    *twodee.Grid
+		costs   *CostGrid
 	)
-	if data, err = ioutil.ReadFile("assets/levels/level2/map.tmx"); err != nil {
+	if doc, m, err = tiled.LoadMap(filepath.Join(dir, LevelMapFile), seed); err != nil {
 		return
 	}
-	if m, err = tmxgo.ParseMapString(string(data)); err != nil {
+	if objects, err = doc.Objects(); err != nil {
 		return
 	}
-	if tiles, err = m.TilesFromLayerName("ground"); err != nil {
+	if grid, err = GetCollisionGrid(m); err != nil {
 		return
 	}
-	WriteGrid(m)
-	if path, err = tmxgo.GetTexturePath(tiles); err != nil {
+	if costs, err = GetCostGrid(doc, grid); err != nil {
 		return
 	}
-	textiles = make([]twodee.TexturedTile, len(tiles))
-	for i, t := range tiles {
-		textiles[i] = t
+	level = &Level{
+		Name: name,
+		Dir:  dir,
+		Doc:  doc,
+		Map:  m,
+		Collision: NewCollisionGrid(
+			grid,
+			float32(m.TileWidth)/LevelPxPerUnit,
+			float32(m.TileHeight)/LevelPxPerUnit,
+		),
+		Costs: costs,
 	}
-	var (
-		tilem = twodee.TileMetadata{
-			Path:      "assets/levels/level2/" + path,
-			PxPerUnit: 32,
-		}
-	)
-	out, err = twodee.LoadBatch(textiles, tilem)
+	level.AddObjects(objects)
 	return
 }
 
-func GetSpritesheet() (sheet *twodee.Spritesheet, texture *twodee.Texture, err error) {
+// GetLevelLayer splits a tile layer into chunks of chunkSize tiles, each
+// loaded into its own batch the first time it is drawn.  A chunkSize of 0
+// loads the whole layer as one batch.  Layers without any tiles have nothing
+// to draw and return nil.
+func GetLevelLayer(m *tmxgo.Map, dir, name string, chunkSize int32) (layer *LevelLayer, err error) {
 	var (
-		data []byte
+		tiles  []*tmxgo.Tile
+		path   string
+		chunks []*LevelChunk
 	)
-	if data, err = ioutil.ReadFile("assets/textures/spritesheet.json"); err != nil {
+	if tiles, err = m.TilesFromLayerName(name); err != nil {
 		return
 	}
-	if sheet, err = twodee.ParseTexturePackerJSONArrayString(string(data), 32); err != nil {
+	chunks = GetLevelChunks(
+		m,
+		tiles,
+		chunkSize,
+		float32(m.TileWidth)/LevelPxPerUnit,
+		float32(m.TileHeight)/LevelPxPerUnit,
+	)
+	if len(chunks) == 0 {
 		return
 	}
-	if texture, err = twodee.LoadTexture("assets/textures/"+sheet.TexturePath, twodee.Nearest); err != nil {
+	if path, err = tmxgo.GetTexturePath(tiles); err != nil {
 		return
 	}
+	layer = &LevelLayer{
+		Name:   name,
+		Chunks: chunks,
+		Metadata: twodee.TileMetadata{
+			Path:      filepath.Join(dir, path),
+			PxPerUnit: LevelPxPerUnit,
+		},
+		Opacity: 1,
+	}
 	return
 }
 
 func NewGameLayer(winb twodee.Rectangle, state *State, app *Application) (layer *GameLayer, err error) {
 	var (
 		camera       *twodee.Camera
+		levels       *LevelManager
 		cameraBounds = twodee.Rect(-10, -10, 10, 10)
-		decay        = twodee.SineDecayFunc(time.Duration(1)*time.Second, 0.5, 5.0, 1.0)
 	)
 	if camera, err = twodee.NewCamera(cameraBounds, winb); err != nil {
 		return
 	}
+	if levels, err = NewLevelManager("assets/levels", state.LevelName, state.LevelSeed); err != nil {
+		return
+	}
 	layer = &GameLayer{
-		shake:        twodee.NewContinuousAnimation(decay),
-		camera:       camera,
-		cameraBounds: cameraBounds,
-		state:        state,
-		player: twodee.NewAnimatingEntity(
-			0, 0,
-			1, 1,
-			0,
-			twodee.Step10Hz,
-			[]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
-		),
-		app:          app,
-		lineSegments: []mgl32.Vec2{mgl32.Vec2{0, 0}},
+		shake:       NewScreenShake(ShakeMaxOffset, ShakeMaxRoll, ShakeFrequency, ShakeDecay),
+		sprites:     NewSpriteQueue(nil),
+		glowSprites: make([]twodee.SpriteConfig, 1),
+		camera:      camera,
+		screen:      winb,
+		state:       state,
+		levels:      levels,
+		world:       NewWorld(int(state.ObjectCount) + 3),
+		movement:    &MovementSystem{},
+		app:         app,
+		registry:    NewEntityRegistry(),
+	}
+	layer.spawnEntities()
+	layer.playerAnim = NewPlayerAnimation(layer.player, app.GameEventHandler)
+	layer.playerPath = NewPathFollower(layer.player.ID)
+	if err = layer.SetViewMode(ViewSingle); err != nil {
+		return
 	}
-	err = layer.Reset()
+	layer.shake.Limits["hit"] = 0.4
+	layer.shake.Limits["explosion"] = 1
+	layer.registry.Register("player", layer.spawnPlayer)
+	layer.registry.Register("enemy", NewSpriteEntityConstructor("numbered_squares_tall_07"))
+	layer.registry.Register("pickup", NewSpriteEntityConstructor("numbered_squares_wide_14"))
+	levels.OnLoad = layer.onLevelLoaded
+	layer.loadLevelObserverId = app.GameEventHandler.AddObserver(LoadLevel, layer.OnLoadLevel)
+	layer.triggerEnterObserverId = app.GameEventHandler.AddObserver(TriggerEnter, layer.OnTriggerEnter)
+	layer.shakeObserverId = app.GameEventHandler.AddObserver(Shake, layer.OnShake)
+	if layer.script, err = twodee.NewScripting(); err != nil {
+		return
+	}
+	if err = layer.script.LoadScript("assets/scripts/main.js"); err != nil {
+		return
+	}
+	if err = layer.Reset(); err != nil {
+		return
+	}
+	// Loading the level spawns triggers, which need the script loaded, and
+	// sprites, which need the frames Reset registers.
+	if err = levels.Reload(); err != nil {
+		return
+	}
+	fmt.Printf("Level seed: %v\n", state.LevelSeed)
+	app.GameEventHandler.Enqueue(twodee.NewBasicGameEvent(BGMusic))
 	return
 }
 
+// spawnEntities creates the player and the test objects which are in every
+// level.  The swarm is spawned with each level.
+func (gl *GameLayer) spawnEntities() {
+	var w = gl.world
+	gl.player = w.Ref(w.Create())
+	w.SetTransform(gl.player.ID, Transform{})
+	w.SetVelocity(gl.player.ID, Velocity{})
+	w.SetSprite(gl.player.ID, Sprite{Layer: SpriteLayerActors, Frame: NoFrame})
+	w.SetAnimation(gl.player.ID, AnimationPlayer{})
+	w.SetCollider(gl.player.ID, Collider{Width: PlayerSize, Height: PlayerSize})
+	gl.tall = w.Create()
+	w.SetTransform(gl.tall, Transform{})
+	w.SetSprite(gl.tall, Sprite{Layer: SpriteLayerActors, Frame: NoFrame})
+	gl.wide = w.Create()
+	w.SetTransform(gl.wide, Transform{})
+	w.SetSprite(gl.wide, Sprite{Layer: SpriteLayerActors, Frame: NoFrame})
+}
+
+// spawnPlayer moves the existing player to a "player" object.
+func (gl *GameLayer) spawnPlayer(obj *LevelObject) (entity *LevelEntity, err error) {
+	gl.player.MoveTo(obj.Center())
+	gl.playerPath.SetPath(nil)
+	return
+}
+
+// onLevelLoaded replaces the spawned entities and triggers with those of a
+// newly loaded level.
+func (gl *GameLayer) onLevelLoaded(level *Level) {
+	var err error
+	for _, e := range gl.entities {
+		gl.world.Destroy(e.ID)
+	}
+	if gl.entities, err = gl.registry.Spawn(level.Objects); err != nil {
+		fmt.Printf("Problem spawning entities: %v\n", err)
+	}
+	for _, e := range gl.entities {
+		e.Spawn(gl.world, gl.sprites.FrameByName(gl.sheet, e.Sprite))
+	}
+	gl.triggers = NewTriggerSystem(level.Objects, gl.player, gl.app.GameEventHandler, gl.script)
+	if gl.swarm != nil {
+		gl.swarm.Despawn(gl.world)
+	}
+	gl.swarm = NewSwarm(level.Collision, level.Costs, gl.pathOptions())
+	gl.swarm.Speed = SwarmSpeed
+	gl.spawnSwarm()
+	gl.movement.Grid = level.Collision
+	// The player is steered before anything moves.  Once everything has
+	// moved, animations are advanced, the player's animation is picked from
+	// how far it went, the tall square catches up with it and triggers fire
+	// for where it ended up.
+	gl.systems = Systems{
+		gl.playerPath,
+		gl.swarm,
+		gl.movement,
+		AnimationSystem{},
+		gl.playerAnim,
+		FollowSystem{Entity: gl.tall, Target: gl.player.ID, Offset: twodee.Pt(-1, -2)},
+		gl.triggers,
+	}
+	gl.resetViewports()
+}
+
+// ViewMode picks how the window is split into viewports.
+type ViewMode int
+
+const (
+	// One view of the player filling the window.
+	ViewSingle ViewMode = iota
+
+	// The player on the left and the first enemy on the right, as a
+	// stand in for a second player.
+	ViewSplit
+
+	// The player filling the window, with a zoomed out overview in the
+	// corner.
+	ViewPictureInPicture
+
+	numViewModes
+)
+
+// SetViewMode replaces the viewports with those of a mode.
+func (gl *GameLayer) SetViewMode(mode ViewMode) (err error) {
+	var (
+		w         = gl.screen.Max.X - gl.screen.Min.X
+		h         = gl.screen.Max.Y - gl.screen.Min.Y
+		viewports []*Viewport
+		vp        *Viewport
+	)
+	add := func(name string, screen twodee.Rectangle) {
+		if err != nil {
+			return
+		}
+		if vp, err = NewViewport(name, screen, ViewPxPerUnit); err != nil {
+			return
+		}
+		vp.Follow.DeadzoneWidth = CameraDeadzoneWidth
+		vp.Follow.DeadzoneHeight = CameraDeadzoneHeight
+		vp.Follow.Smoothing = CameraSmoothing
+		vp.Follow.LookAhead = CameraLookAhead
+		vp.Follow.MinZoom = CameraMinZoom
+		vp.Follow.MaxZoom = CameraMaxZoom
+		vp.Follow.ZoomSmoothing = CameraZoomSmoothing
+		viewports = append(viewports, vp)
+	}
+	switch mode {
+	case ViewSplit:
+		add("left", twodee.Rect(0, 0, w/2, h))
+		add("right", twodee.Rect(w/2, 0, w, h))
+	case ViewPictureInPicture:
+		add("main", twodee.Rect(0, 0, w, h))
+		add("overview", twodee.Rect(w*0.65, h*0.65, w-10, h-10))
+		if vp != nil {
+			vp.Follow.MinZoom = ViewOverviewZoom
+			vp.Follow.SetZoom(ViewOverviewZoom)
+		}
+	default:
+		mode = ViewSingle
+		add("main", twodee.Rect(0, 0, w, h))
+	}
+	if err != nil {
+		return
+	}
+	gl.viewMode = mode
+	gl.viewports = viewports
+	if gl.levels.Current != nil {
+		gl.resetViewports()
+	}
+	return
+}
+
+// resetViewports fits the viewports to the current level and centers them
+// on what they follow.
+func (gl *GameLayer) resetViewports() {
+	var bounds = gl.levels.Current.Bounds()
+	for i, vp := range gl.viewports {
+		vp.Follow.Bounds = bounds
+		if gl.viewMode == ViewSplit && i == 1 {
+			vp.Follow.Follow(gl.secondTarget())
+		} else {
+			vp.Follow.Follow(gl.player)
+		}
+		vp.Follow.Snap()
+	}
+}
+
+// secondTarget is what the right half of the split screen follows: the
+// first enemy, or the player if there are none.
+func (gl *GameLayer) secondTarget() twodee.Entity {
+	for _, e := range gl.entities {
+		if e.Object.Type == "enemy" {
+			return gl.world.Ref(e.ID)
+		}
+	}
+	return gl.player
+}
+
+// spawnSwarm scatters the objects chasing the player over the level, each
+// turned a little further than the last.
+func (gl *GameLayer) spawnSwarm() {
+	gl.swarm.Spawn(gl.world, int(gl.state.ObjectCount), rand.New(rand.NewSource(gl.state.LevelSeed)))
+	for i, id := range gl.swarm.Members {
+		gl.world.Transforms[id.Index()].Rotation = mgl32.DegToRad(float32(i * 15))
+		gl.world.SetSprite(id, Sprite{Layer: SpriteLayerSwarm, Frame: gl.frames.Numbered[i%NumberedFrames]})
+	}
+}
+
+func (gl *GameLayer) pathOptions() PathOptions {
+	return PathOptions{
+		Diagonal:    gl.state.PathDiagonal != 0,
+		MaxExpanded: PathMaxExpanded,
+	}
+}
+
+// Reset recreates the layer's GL resources, for when the GL context has been
+// reset.  Everything spawned in the level stays where it is.
 func (gl *GameLayer) Reset() (err error) {
 	if gl.batch != nil {
 		gl.batch.Delete()
 	}
-	if gl.level != nil {
-		gl.level.Delete()
-	}
 	if gl.glow != nil {
 		gl.glow.Delete()
 	}
@@ -174,142 +568,299 @@ func (gl *GameLayer) Reset() (err error) {
 	if gl.sprite, err = twodee.NewSpriteRenderer(gl.camera); err != nil {
 		return
 	}
+	gl.sprites.Drawer = TextureDrawer{gl.sprite}
 	if gl.lines, err = twodee.NewLinesRenderer(gl.camera); err != nil {
 		return
 	}
-	if gl.level, err = GetLevel(); err != nil {
+	if gl.sheet != nil {
+		gl.sheet.Delete()
+	}
+	if gl.sheet, err = GetSpritesheet(GameSpritesheet); err != nil {
 		return
 	}
-	if gl.sheet, gl.sheetTexture, err = GetSpritesheet(); err != nil {
+	if gl.playerSheet != nil {
+		gl.playerSheet.Delete()
+	}
+	var player = gl.player.Animation()
+	if gl.playerSheet, player.Animations, err = GetAsepriteSheet(PlayerSpritesheet); err != nil {
 		return
 	}
-	if gl.script, err = twodee.NewScripting(); err != nil {
+	// Restart the player's state with the reloaded animations.
+	var state = gl.playerAnim.State()
+	if state == "" {
+		state = PlayerIdle
+	}
+	if err = gl.playerAnim.Trigger(state); err != nil {
 		return
 	}
-	if err = gl.script.LoadScript("assets/scripts/main.js"); err != nil {
+	gl.sprites.ClearFrames()
+	if gl.frames, err = GetGameFrames(gl.sprites, gl.sheet, gl.playerSheet); err != nil {
 		return
 	}
-	gl.app.GameEventHandler.Enqueue(twodee.NewBasicGameEvent(BGMusic))
-	return
+	player.Frames = gl.frames.Player
+	gl.world.Sprites[gl.player.ID.Index()].Frame = gl.frames.Player[player.Frame()]
+	gl.world.Sprites[gl.tall.Index()].Frame = gl.frames.Tall
+	gl.world.Sprites[gl.wide.Index()].Frame = gl.frames.Wide
+	if gl.swarm != nil {
+		for i, id := range gl.swarm.Members {
+			gl.world.Sprites[id.Index()].Frame = gl.frames.Numbered[i%NumberedFrames]
+		}
+	}
+	for _, e := range gl.entities {
+		gl.world.Sprites[e.ID.Index()].Frame = gl.sprites.FrameByName(gl.sheet, e.Sprite)
+	}
+	return gl.levels.ReloadLayers()
 }
 
 func (gl *GameLayer) Delete() {
+	gl.app.GameEventHandler.RemoveObserver(LoadLevel, gl.loadLevelObserverId)
+	gl.app.GameEventHandler.RemoveObserver(TriggerEnter, gl.triggerEnterObserverId)
+	gl.app.GameEventHandler.RemoveObserver(Shake, gl.shakeObserverId)
 	gl.batch.Delete()
-	gl.level.Delete()
+	gl.levels.Delete()
 	gl.glow.Delete()
 	gl.sprite.Delete()
 	gl.lines.Delete()
-	gl.sheetTexture.Delete()
+	gl.sheet.Delete()
+	gl.playerSheet.Delete()
 }
 
 func (gl *GameLayer) Render() {
+	var alpha = gl.app.Alpha
+	gl.sprites.Stats = SpriteStats{}
+	gl.rollDrawn = gl.rollPrev + (gl.roll-gl.rollPrev)*alpha
+	for i, vp := range gl.viewports {
+		vp.Follow.Interpolate(alpha)
+		vp.Bind(gl.camera, gl.screen)
+		gl.renderViewport(vp)
+		if i > 0 {
+			gl.renderBorder()
+		}
+	}
+	UnbindViewports(gl.screen)
+}
+
+// renderViewport draws the world through a bound viewport.
+func (gl *GameLayer) renderViewport(vp *Viewport) {
 	var (
-		count                          = int(gl.state.ObjectCount)
-		tiles    []twodee.SpriteConfig = make([]twodee.SpriteConfig, count)
-		player   []twodee.SpriteConfig = make([]twodee.SpriteConfig, 1)
-		rando    []twodee.SpriteConfig
-		frame    *twodee.SpritesheetFrame
-		frame1   *twodee.SpritesheetFrame = gl.sheet.GetFrame("numbered_squares_tall_07")
-		frame2   *twodee.SpritesheetFrame = gl.sheet.GetFrame("numbered_squares_wide_14")
-		coord    float32
-		playerPt = gl.player.Pos()
+		playerPt    = gl.world.Interpolated(gl.player.ID, gl.app.Alpha).Pos
+		playerFrame = gl.world.Sprites[gl.player.ID.Index()].Frame
+		roll        = gl.viewRoll(vp)
 	)
 	gl.batch.Bind()
-	if err := gl.batch.Draw(gl.level, 0, 0, 0); err != nil {
+	if err := gl.levels.Current.Draw(gl.batch, false, roll, gl.camera.WorldBounds); err != nil {
 		panic(err)
 	}
 	gl.batch.Unbind()
 
-	gl.sheetTexture.Bind()
-
-	for i := 0; i < count; i++ {
-		frame = gl.sheet.GetFrame(fmt.Sprintf("numbered_squares_%02d", (i%16)+1))
-		coord = float32(i-(count/2)) / (float32(count) / 20.0)
-		tiles[i] = twodee.SpriteConfig{
-			View: twodee.ModelViewConfig{
-				coord, coord, 0,
-				mgl32.DegToRad(float32(i * 15)), 0.0, 0.0,
-				1.0, 1.0, 1.0,
-			},
-			Frame: frame.Frame,
-		}
-	}
-
-	frame = gl.sheet.GetFrame(fmt.Sprintf("numbered_squares_%02d", gl.player.Frame()+1))
-	player[0] = twodee.SpriteConfig{
-		View: twodee.ModelViewConfig{
-			playerPt.X, playerPt.Y, 0,
-			0, 0, 0,
-			1.0, 1.0, 1.0,
-		},
-		Frame: frame.Frame,
-	}
+	gl.glowSprites[0] = gl.sprites.Sprite(playerFrame, twodee.ModelViewConfig{
+		playerPt.X, playerPt.Y, 0,
+		0, 0, 0,
+		1.0, 1.0, 1.0,
+	})
+	roll.Sprites(gl.glowSprites)
 
+	gl.playerSheet.Texture.Bind()
 	gl.glow.Bind()
-	gl.sprite.Draw(player)
+	gl.sprite.Draw(gl.glowSprites)
 	gl.glow.Unbind()
+	gl.playerSheet.Texture.Unbind()
+	// The glow renderer draws to its own buffer and resets the GL viewport.
+	vp.Bind(gl.camera, gl.screen)
 
-	rando = []twodee.SpriteConfig{
-		twodee.SpriteConfig{
-			View: twodee.ModelViewConfig{
-				playerPt.X - 1.0, playerPt.Y - 2.0, 0,
-				0, 0, 0,
-				1.0, 1.0, 1.0,
-			},
-			Frame: frame1.Frame,
-		},
-		twodee.SpriteConfig{
-			View: twodee.ModelViewConfig{
-				0, 0, 0,
-				0, 0, 0,
-				1.0, 1.0, 1.0,
-			},
-			Frame: frame2.Frame,
-		},
+	gl.pushSprites(gl.camera.WorldBounds, roll)
+	if err := gl.sprites.Flush(); err != nil {
+		panic(err)
 	}
-
-	gl.sprite.Draw(tiles)
-	gl.sprite.Draw(rando)
-	gl.sprite.Draw(player)
 	gl.glow.Draw()
-	gl.sheetTexture.Unbind()
 
-	if len(gl.lineSegments) > 1 {
-		line := twodee.NewLineGeometry(gl.lineSegments, false)
-		style := &twodee.LineStyle{
-			Thickness: 0.2,
-			Color:     color.RGBA{0, 0, 255, 128},
-			Inner:     0.0,
+	gl.batch.Bind()
+	if err := gl.levels.Current.Draw(gl.batch, true, roll, gl.camera.WorldBounds); err != nil {
+		panic(err)
+	}
+	gl.batch.Unbind()
+
+	if gl.playerPath.Active() {
+		// Reuse the last frame's points rather than building a new slice.
+		gl.pathLine = append(gl.pathLine[:0], mgl32.Vec2{playerPt.X, playerPt.Y})
+		for _, pt := range gl.playerPath.Remaining() {
+			gl.pathLine = append(gl.pathLine, mgl32.Vec2{pt.X, pt.Y})
 		}
-		modelview := mgl32.Ident4()
+		line := twodee.NewLineGeometry(gl.pathLine, false)
+		modelview := roll.Mat4()
 		gl.lines.Bind()
-		gl.lines.Draw(line, modelview, style)
+		gl.lines.Draw(line, modelview, pathLineStyle)
 		gl.lines.Unbind()
 	}
+
+	if fade := gl.levels.Fade(); fade > 0 {
+		gl.renderFade(fade)
+	}
+}
+
+// pathLineStyle is how the player's remaining path is drawn.
+var pathLineStyle = &twodee.LineStyle{
+	Thickness: 0.2,
+	Color:     color.RGBA{0, 0, 255, 128},
+	Inner:     0.0,
+}
+
+// pushSprites queues everything the game draws as a sprite.
+func (gl *GameLayer) pushSprites(view twodee.Rectangle, roll Roll) {
+	gl.sprites.Begin(view, roll)
+	PushSprites(gl.world, gl.sprites, gl.app.Alpha)
+}
+
+// renderBorder outlines the bound viewport, to set it apart from the ones
+// it is drawn over.
+func (gl *GameLayer) renderBorder() {
+	var (
+		bounds = gl.camera.WorldBounds
+		inset  = (bounds.Max.X - bounds.Min.X) / 100
+		line   = twodee.NewLineGeometry([]mgl32.Vec2{
+			mgl32.Vec2{bounds.Min.X + inset, bounds.Min.Y + inset},
+			mgl32.Vec2{bounds.Max.X - inset, bounds.Min.Y + inset},
+			mgl32.Vec2{bounds.Max.X - inset, bounds.Max.Y - inset},
+			mgl32.Vec2{bounds.Min.X + inset, bounds.Max.Y - inset},
+		}, true)
+		style = &twodee.LineStyle{
+			Thickness: inset * 2,
+			Color:     color.RGBA{255, 255, 255, 255},
+			Inner:     0.0,
+		}
+	)
+	gl.lines.Bind()
+	gl.lines.Draw(line, mgl32.Ident4(), style)
+	gl.lines.Unbind()
+}
+
+// renderFade covers the camera's view with black at the given opacity.
+func (gl *GameLayer) renderFade(fade float32) {
+	var (
+		bounds = gl.camera.WorldBounds
+		midy   = (bounds.Min.Y + bounds.Max.Y) / 2
+		line   = twodee.NewLineGeometry([]mgl32.Vec2{
+			mgl32.Vec2{bounds.Min.X, midy},
+			mgl32.Vec2{bounds.Max.X, midy},
+		}, false)
+		style = &twodee.LineStyle{
+			Thickness: bounds.Max.Y - bounds.Min.Y,
+			Color:     color.RGBA{0, 0, 0, uint8(fade * 255)},
+			Inner:     0.0,
+		}
+	)
+	gl.lines.Bind()
+	gl.lines.Draw(line, mgl32.Ident4(), style)
+	gl.lines.Unbind()
 }
 
 func (gl *GameLayer) Update(elapsed time.Duration) {
 	gl.shake.Update(elapsed)
-	bounds := twodee.Rect(
-		gl.cameraBounds.Min.X,
-		gl.cameraBounds.Min.Y+gl.shake.Value(),
-		gl.cameraBounds.Max.X,
-		gl.cameraBounds.Max.Y+gl.shake.Value(),
-	)
-	gl.camera.SetWorldBounds(bounds)
-	gl.player.Update(elapsed)
+	gl.updateSwarm()
+	gl.playerPath.Speed = float32(gl.state.PlayerSpeed)
+	gl.systems.Update(gl.world, elapsed)
+	if err := gl.levels.Update(elapsed); err != nil {
+		fmt.Printf("Problem loading level: %v\n", err)
+	}
+	// Follow the player once it has moved, so the camera does not lag a
+	// frame behind it.
+	var x, y, roll = gl.shake.Offset()
+	for _, vp := range gl.viewports {
+		vp.Follow.Update(elapsed, twodee.Pt(x, y))
+	}
+	gl.rollPrev = gl.roll
+	gl.roll = roll
+}
+
+// viewRoll returns the shake's roll, as last drawn, around the middle of a
+// viewport.
+func (gl *GameLayer) viewRoll(vp *Viewport) Roll {
+	var bounds = vp.Camera.WorldBounds
+	return Roll{
+		Center: twodee.Pt(
+			(bounds.Min.X+bounds.Max.X)/2,
+			(bounds.Min.Y+bounds.Max.Y)/2,
+		),
+		Angle: gl.rollDrawn,
+	}
+}
+
+// mouseViewport returns the viewport under the mouse and the world point it
+// shows there, allowing for any roll.  vp is nil if the mouse is outside
+// every viewport.
+func (gl *GameLayer) mouseViewport() (vp *Viewport, pt twodee.Point) {
+	if vp = ViewportAt(gl.viewports, gl.mousex, gl.mousey); vp != nil {
+		pt = gl.viewRoll(vp).Unroll(vp.ScreenToWorld(gl.mousex, gl.mousey))
+	}
+	return
+}
+
+// updateSwarm points the objects at the player, ready for the swarm system
+// to steer them.  The flow field they follow is only recomputed when the
+// player changes cells.
+func (gl *GameLayer) updateSwarm() {
+	if len(gl.swarm.Members) != int(gl.state.ObjectCount) {
+		gl.spawnSwarm()
+	}
+	gl.swarm.SetOptions(gl.pathOptions())
+	gl.swarm.SetGoal(gl.player.Pos())
+}
+
+// walkTo sends the player along a path to the tile containing pt.
+func (gl *GameLayer) walkTo(pt twodee.Point) {
+	var level = gl.levels.Current
+	path, err := level.Collision.GetPath(gl.player.Pos(), pt, level.Costs, gl.pathOptions())
+	if err != nil {
+		fmt.Printf("No path to %v: %v\n", pt, err)
+		return
+	}
+	gl.playerPath.Replan = func(from twodee.Point) ([]twodee.Point, error) {
+		return level.Collision.GetPath(from, pt, level.Costs, gl.pathOptions())
+	}
+	gl.playerPath.SetPath(path)
+	if main := gl.viewports[0].Follow; main.Target == nil {
+		main.Follow(gl.player)
+	}
+}
+
+// OnTriggerEnter loads the level named by a trigger's "level" property.
+func (gl *GameLayer) OnTriggerEnter(e twodee.GETyper) {
+	if event, ok := e.(*TriggerEvent); ok {
+		if name, ok := event.Trigger.Object.Properties["level"]; ok {
+			gl.app.GameEventHandler.Enqueue(NewLoadLevelEvent(name))
+		}
+	}
+}
+
+func (gl *GameLayer) OnShake(e twodee.GETyper) {
+	if event, ok := e.(*ShakeEvent); ok {
+		gl.shake.Add(event.Source, event.Trauma)
+	}
+}
+
+func (gl *GameLayer) OnLoadLevel(e twodee.GETyper) {
+	if event, ok := e.(*LoadLevelEvent); ok {
+		if err := gl.levels.Load(event.Name); err != nil {
+			fmt.Printf("Problem loading level: %v\n", err)
+		}
+	}
 }
 
 func (gl *GameLayer) HandleEvent(evt twodee.Event) bool {
 	var err error
 	switch event := evt.(type) {
 	case *twodee.MouseMoveEvent:
-		worldx, worldy := gl.camera.ScreenToWorldCoords(event.X, event.Y)
-		gl.player.MoveTo(twodee.Pt(worldx, worldy))
+		// Kept in screen coordinates, since the camera may move before
+		// the next click.
+		gl.mousex, gl.mousey = event.X, event.Y
+	case *MouseWheelEvent:
+		// Zoom the viewport under the cursor around the cursor.
+		if vp, pt := gl.mouseViewport(); vp != nil {
+			vp.Follow.ZoomAt(float32(math.Pow(CameraZoomStep, float64(event.DY))), pt)
+		}
 	case *twodee.MouseButtonEvent:
-		if event.Type == twodee.Press {
-			pos := gl.player.Pos()
-			gl.lineSegments = append(gl.lineSegments, mgl32.Vec2{pos.X, pos.Y})
+		if vp, pt := gl.mouseViewport(); vp != nil && event.Type == twodee.Press {
+			gl.walkTo(pt)
 		}
 	case *twodee.KeyEvent:
 		if event.Type == twodee.Release {
@@ -318,19 +869,20 @@ func (gl *GameLayer) HandleEvent(evt twodee.Event) bool {
 		var dist float32 = 0.2
 		switch event.Code {
 		case twodee.KeyLeft:
-			gl.cameraBounds.Min.X -= dist
-			gl.cameraBounds.Max.X -= dist
+			gl.viewports[0].Follow.Pan(-dist, 0)
 		case twodee.KeyRight:
-			gl.cameraBounds.Min.X += dist
-			gl.cameraBounds.Max.X += dist
+			gl.viewports[0].Follow.Pan(dist, 0)
 		case twodee.KeyUp:
-			gl.cameraBounds.Min.Y += dist
-			gl.cameraBounds.Max.Y += dist
+			gl.viewports[0].Follow.Pan(0, dist)
 		case twodee.KeyDown:
-			gl.cameraBounds.Min.Y -= dist
-			gl.cameraBounds.Max.Y -= dist
+			gl.viewports[0].Follow.Pan(0, -dist)
 		case twodee.KeyS:
-			gl.shake.Reset()
+			gl.app.GameEventHandler.Enqueue(NewShakeEvent("explosion", 0.6))
+		case twodee.KeyH:
+			gl.app.GameEventHandler.Enqueue(NewShakeEvent("hit", 0.2))
+			if err := gl.playerAnim.Trigger(PlayerHurt); err != nil {
+				fmt.Printf("Problem playing animation: %v\n", err)
+			}
 		case twodee.KeyM:
 			if twodee.MusicIsPaused() {
 				gl.app.GameEventHandler.Enqueue(twodee.NewBasicGameEvent(ResumeMusic))
@@ -341,6 +893,16 @@ func (gl *GameLayer) HandleEvent(evt twodee.Event) bool {
 			if err = gl.script.TriggerEvent("foo", gl.player); err != nil {
 				fmt.Printf("Problem triggering event: %v\n", err)
 			}
+		case twodee.KeyV:
+			if err = gl.SetViewMode((gl.viewMode + 1) % numViewModes); err != nil {
+				fmt.Printf("Problem changing views: %v\n", err)
+			}
+		case twodee.KeyN:
+			gl.app.GameEventHandler.Enqueue(NewLoadLevelEvent(gl.levels.Next()))
+		case twodee.KeyL:
+			if err = gl.script.TriggerEvent("level", gl.levels); err != nil {
+				fmt.Printf("Problem triggering event: %v\n", err)
+			}
 		}
 	}
 	return true
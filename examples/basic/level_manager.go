@@ -0,0 +1,367 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	twodee "../../libs/twodee"
+	tiled "../tiled"
+	"fmt"
+	"github.com/go-gl/gl/v3.3-core/gl"
+	"github.com/kurrik/tmxgo"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	LevelMapFile = "map.tmx"
+
+	// Map pixels per world unit.
+	LevelPxPerUnit = 32
+
+	// Tile layers with this property set to true are drawn above sprites.
+	LevelForegroundProperty = "foreground"
+)
+
+// LevelLayer is a single visible tile layer of a level, split into chunks.
+// Every chunk draws from the layer's tileset texture, which is loaded once
+// when the first chunk is.
+type LevelLayer struct {
+	Name       string
+	Chunks     []*LevelChunk
+	Metadata   twodee.TileMetadata
+	Texture    *twodee.Texture
+	Opacity    float32
+	Foreground bool
+}
+
+// Draw renders the chunks which overlap view, loading any which have not
+// been drawn before.  The renderer must already be bound.
+func (l *LevelLayer) Draw(renderer *twodee.BatchRenderer, view twodee.Rectangle, x, y, rot float32) (err error) {
+	for _, chunk := range l.Chunks {
+		if !overlaps(chunk.Bounds, view) {
+			continue
+		}
+		if l.Texture == nil {
+			if l.Texture, err = twodee.LoadTexture(l.Metadata.Path, twodee.Nearest); err != nil {
+				return
+			}
+		}
+		if err = chunk.Load(l.Texture, l.Metadata); err != nil {
+			return
+		}
+		if err = renderer.Draw(chunk.Batch, x, y, rot); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (l *LevelLayer) Delete() {
+	for _, chunk := range l.Chunks {
+		chunk.Delete()
+	}
+	if l.Texture != nil {
+		l.Texture.Delete()
+		l.Texture = nil
+	}
+}
+
+// Level is a loaded map and the GL resources used to draw it.  Layers are in
+// document order, so earlier layers are drawn underneath later ones, and are
+// only created by LoadLayers.
+//
+// The map's bottom left corner sits at the world origin, with Y pointing up.
+type Level struct {
+	Name      string
+	Dir       string
+	Doc       *tiled.TmxDocument
+	Map       *tmxgo.Map
+	Layers    []*LevelLayer
+	Objects   []*LevelObject
+	Collision *CollisionGrid
+	Costs     *CostGrid
+}
+
+// PxToWorld converts map pixel coordinates, measured down from the top left
+// of the map, into world coordinates.
+func (l *Level) PxToWorld(x, y float32) (float32, float32) {
+	var height = float32(l.Map.Height * l.Map.TileHeight)
+	return x / LevelPxPerUnit, (height - y) / LevelPxPerUnit
+}
+
+// Bounds returns the area the map covers in world coordinates.
+func (l *Level) Bounds() twodee.Rectangle {
+	var maxx, maxy = l.PxToWorld(float32(l.Map.Width*l.Map.TileWidth), 0)
+	return twodee.Rect(0, 0, maxx, maxy)
+}
+
+// AddObjects converts TMX objects into world space and adds them to the level.
+func (l *Level) AddObjects(objects []*tiled.TmxObject) {
+	for _, obj := range objects {
+		var (
+			minx, maxy = l.PxToWorld(obj.X, obj.Y)
+			maxx, miny = l.PxToWorld(obj.X+obj.Width, obj.Y+obj.Height)
+		)
+		l.Objects = append(l.Objects, &LevelObject{
+			Group:      obj.Group,
+			Name:       obj.Name,
+			Type:       obj.Type,
+			Bounds:     twodee.Rect(minx, miny, maxx, maxy),
+			Properties: obj.Properties,
+		})
+	}
+}
+
+// LoadLayers creates the GL resources for every visible tile layer,
+// replacing any created before.
+func (l *Level) LoadLayers() (err error) {
+	var layer *LevelLayer
+	l.Delete()
+	for _, name := range l.Doc.LayerNames() {
+		if !l.Doc.LayerVisible(name) {
+			continue
+		}
+		if layer, err = GetLevelLayer(l.Map, l.Dir, name, LevelChunkSize); err != nil {
+			l.Delete()
+			return
+		}
+		if layer == nil {
+			continue
+		}
+		layer.Opacity = l.Doc.LayerOpacity(name)
+		layer.Foreground, _ = strconv.ParseBool(l.Doc.LayerProperty(name, LevelForegroundProperty))
+		l.Layers = append(l.Layers, layer)
+	}
+	return
+}
+
+func (l *Level) Delete() {
+	for _, layer := range l.Layers {
+		layer.Delete()
+	}
+	l.Layers = nil
+}
+
+// Draw renders either the background or the foreground layers of the level,
+// turned by roll.  Only the chunks under view are drawn.  The renderer must
+// already be bound.
+func (l *Level) Draw(renderer *twodee.BatchRenderer, foreground bool, roll Roll, view twodee.Rectangle) (err error) {
+	var (
+		origin  = roll.Point(twodee.Pt(0, 0))
+		covered = roll.Covering(view)
+	)
+	for _, layer := range l.Layers {
+		if layer.Foreground != foreground || layer.Opacity <= 0 {
+			continue
+		}
+		if layer.Opacity < 1 {
+			// The batch shader has no opacity uniform, so scale the whole
+			// layer with a constant blend instead.  Tile textures are opaque,
+			// so nothing is lost by ignoring their alpha here.
+			gl.BlendColor(0, 0, 0, layer.Opacity)
+			gl.BlendFunc(gl.CONSTANT_ALPHA, gl.ONE_MINUS_CONSTANT_ALPHA)
+		}
+		err = layer.Draw(renderer, covered, origin.X, origin.Y, roll.Angle)
+		if layer.Opacity < 1 {
+			gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// LoadLevelEvent asks the LevelManager to switch to the named level.
+type LoadLevelEvent struct {
+	*twodee.BasicGameEvent
+	Name string
+}
+
+func NewLoadLevelEvent(name string) *LoadLevelEvent {
+	return &LoadLevelEvent{
+		BasicGameEvent: twodee.NewBasicGameEvent(LoadLevel),
+		Name:           name,
+	}
+}
+
+// LevelManager finds the levels under a directory and swaps between them.
+// Every subdirectory containing a map.tmx is a level, named after the
+// directory.  Switching levels fades to black, replaces the level and then
+// fades back in.
+type LevelManager struct {
+	Root         string
+	FadeDuration time.Duration
+	Current      *Level
+	OnLoad       func(level *Level)
+	names        []string
+	name         string
+	pending      string
+	fade         float32
+	seed         int64
+}
+
+func NewLevelManager(root, name string, seed int64) (lm *LevelManager, err error) {
+	lm = &LevelManager{
+		Root:         root,
+		FadeDuration: time.Duration(500) * time.Millisecond,
+		name:         name,
+		seed:         seed,
+	}
+	if err = lm.Scan(); err != nil {
+		lm = nil
+		return
+	}
+	if !lm.Exists(name) {
+		err = fmt.Errorf("No level named %v in %v", name, root)
+		lm = nil
+	}
+	return
+}
+
+// Scan refreshes the list of available levels.
+func (lm *LevelManager) Scan() (err error) {
+	var infos []os.FileInfo
+	if infos, err = ioutil.ReadDir(lm.Root); err != nil {
+		return
+	}
+	lm.names = lm.names[:0]
+	for _, info := range infos {
+		if !info.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(lm.Root, info.Name(), LevelMapFile)); err == nil {
+			lm.names = append(lm.names, info.Name())
+		}
+	}
+	return
+}
+
+// Names returns the available levels in alphabetical order.
+func (lm *LevelManager) Names() []string {
+	return lm.names
+}
+
+func (lm *LevelManager) Exists(name string) bool {
+	for _, n := range lm.names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Name returns the level which is loaded, or about to be loaded.
+func (lm *LevelManager) Name() string {
+	if lm.pending != "" {
+		return lm.pending
+	}
+	return lm.name
+}
+
+// Next returns the level after the current one, wrapping around.
+func (lm *LevelManager) Next() string {
+	for i, n := range lm.names {
+		if n == lm.Name() {
+			return lm.names[(i+1)%len(lm.names)]
+		}
+	}
+	return lm.Name()
+}
+
+// Load starts a transition to the named level.  The level is swapped once
+// the screen has faded out; see Update.
+func (lm *LevelManager) Load(name string) (err error) {
+	if !lm.Exists(name) {
+		return fmt.Errorf("No level named %v in %v", name, lm.Root)
+	}
+	lm.pending = name
+	if lm.FadeDuration <= 0 {
+		err = lm.swap()
+	}
+	return
+}
+
+// Reload immediately loads the current level afresh, calling OnLoad as a
+// level switch does.
+func (lm *LevelManager) Reload() (err error) {
+	lm.pending = lm.name
+	return lm.swap()
+}
+
+// ReloadLayers recreates the current level's GL resources, for when the GL
+// context has been reset.  The level is otherwise left as it is, and OnLoad
+// is not called.
+func (lm *LevelManager) ReloadLayers() (err error) {
+	if lm.Current != nil {
+		err = lm.Current.LoadLayers()
+	}
+	return
+}
+
+func (lm *LevelManager) swap() (err error) {
+	var level *Level
+	level, err = GetLevel(lm.pending, filepath.Join(lm.Root, lm.pending), lm.seed)
+	lm.pending = ""
+	if err != nil {
+		return
+	}
+	if err = level.LoadLayers(); err != nil {
+		return
+	}
+	if lm.Current != nil {
+		lm.Current.Delete()
+	}
+	lm.Current = level
+	lm.name = level.Name
+	if lm.OnLoad != nil {
+		lm.OnLoad(level)
+	}
+	return
+}
+
+// Update advances any fade in progress and swaps levels at full black.
+func (lm *LevelManager) Update(elapsed time.Duration) (err error) {
+	var step float32 = 1
+	if lm.FadeDuration > 0 {
+		step = float32(elapsed) / float32(lm.FadeDuration)
+	}
+	if lm.pending != "" {
+		if lm.fade += step; lm.fade >= 1 {
+			lm.fade = 1
+			err = lm.swap()
+		}
+	} else if lm.fade > 0 {
+		if lm.fade -= step; lm.fade < 0 {
+			lm.fade = 0
+		}
+	}
+	return
+}
+
+// Fade returns how far the screen has faded out, from 0 (clear) to 1 (black).
+func (lm *LevelManager) Fade() float32 {
+	return lm.fade
+}
+
+func (lm *LevelManager) Delete() {
+	if lm.Current != nil {
+		lm.Current.Delete()
+		lm.Current = nil
+	}
+}
@@ -16,8 +16,10 @@ package main
 
 import (
 	twodee "../../libs/twodee"
+	"flag"
 	"fmt"
 	"github.com/go-gl/gl/v3.3-core/gl"
+	"github.com/go-gl/glfw/v3.1/glfw"
 	"runtime"
 	"time"
 )
@@ -27,6 +29,14 @@ func init() {
 	runtime.LockOSThread()
 }
 
+// MouseWheelEvent is passed to the layers when the mouse wheel scrolls.
+// twodee does not report scrolling, so Application listens to the window for
+// it directly.
+type MouseWheelEvent struct {
+	DX float32
+	DY float32
+}
+
 type Application struct {
 	layers           *twodee.Layers
 	counter          *twodee.Counter
@@ -35,18 +45,26 @@ type Application struct {
 	State            *State
 	GameEventHandler *twodee.GameEventHandler
 	AudioSystem      *AudioSystem
+	wheel            []*MouseWheelEvent
+
+	// Alpha is how far the frame being drawn is between the last two
+	// updates, from 0 to 1.  Layers draw what moves that far between
+	// where it was and where it is, so that movement stays smooth
+	// whatever the display's refresh rate.
+	Alpha float32
 }
 
-func NewApplication() (app *Application, err error) {
+func NewApplication(seed int64) (app *Application, err error) {
 	var (
 		layers           *twodee.Layers
 		context          *twodee.Context
 		gamelayer        *GameLayer
+		minimaplayer     *MinimapLayer
 		debuglayer       *DebugLayer
 		menulayer        *MenuLayer
 		winbounds        = twodee.Rect(0, 0, 640, 640)
 		counter          = twodee.NewCounter()
-		state            = NewState()
+		state            = NewState(seed)
 		gameEventHandler = twodee.NewGameEventHandler(NumGameEventTypes)
 		audioSystem      *AudioSystem
 	)
@@ -66,13 +84,18 @@ func NewApplication() (app *Application, err error) {
 		State:            state,
 		GameEventHandler: gameEventHandler,
 	}
+	context.Window.SetScrollCallback(app.onScroll)
 	if gamelayer, err = NewGameLayer(winbounds, state, app); err != nil {
 		return
 	}
-	if debuglayer, err = NewDebugLayer(winbounds, counter); err != nil {
+	if minimaplayer, err = NewMinimapLayer(winbounds, gamelayer); err != nil {
+		return
+	}
+	if debuglayer, err = NewDebugLayer(winbounds, counter, gamelayer.sprites); err != nil {
 		return
 	}
 	layers.Push(gamelayer)
+	layers.Push(minimaplayer)
 	layers.Push(debuglayer)
 	fmt.Printf("OpenGL version: %s\n", context.OpenGLVersion)
 	fmt.Printf("Shader version: %s\n", context.ShaderVersion)
@@ -87,7 +110,8 @@ func NewApplication() (app *Application, err error) {
 	return
 }
 
-func (a *Application) Draw() {
+func (a *Application) Draw(alpha float32) {
+	a.Alpha = alpha
 	a.counter.Incr()
 	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
 	a.layers.Render()
@@ -103,6 +127,10 @@ func (a *Application) Delete() {
 	a.Context.Delete()
 }
 
+func (a *Application) onScroll(w *glfw.Window, xoff, yoff float64) {
+	a.wheel = append(a.wheel, &MouseWheelEvent{DX: float32(xoff), DY: float32(yoff)})
+}
+
 func (a *Application) ProcessEvents() {
 	var (
 		evt  twodee.Event
@@ -117,15 +145,21 @@ func (a *Application) ProcessEvents() {
 			loop = false
 		}
 	}
+	for _, evt := range a.wheel {
+		a.layers.HandleEvent(evt)
+	}
+	a.wheel = a.wheel[:0]
 }
 
 func main() {
 	var (
-		app *Application
-		err error
+		app  *Application
+		err  error
+		seed = flag.Int64("seed", time.Now().UnixNano(), "Seed for random level generation")
 	)
+	flag.Parse()
 
-	if app, err = NewApplication(); err != nil {
+	if app, err = NewApplication(*seed); err != nil {
 		panic(err)
 	}
 	defer app.Delete()
@@ -140,7 +174,9 @@ func main() {
 			app.Update(step)
 			updated_to = updated_to.Add(step)
 		}
-		app.Draw()
+		// The last update ran up to a time after now, so draw the
+		// state as it was now, between that update and the one before.
+		app.Draw(1 - float32(updated_to.Sub(current_time))/float32(step))
 		app.Context.Window.SwapBuffers()
 		app.Context.Events.Poll()
 		app.GameEventHandler.Poll()
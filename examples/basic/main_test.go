@@ -0,0 +1,64 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	twodee "../../libs/twodee"
+	"runtime"
+	"testing"
+)
+
+// Level the benchmarks load, and the seed they automap it with.
+const (
+	benchmarkLevel       = "assets/levels/level2"
+	benchmarkSeed  int64 = 1
+)
+
+// Size of the window drawing benchmarks open, and of the view they draw,
+// which match the game's.
+const (
+	benchmarkWindowSize         = 640
+	benchmarkViewSize   float32 = 20
+)
+
+var (
+	windowContext *twodee.Context
+	windowErr     error
+)
+
+// requireWindow opens the window drawing benchmarks share, the first time it
+// is called, and makes its OpenGL context current on the calling thread.  The
+// benchmark is skipped in short mode or if no window can be opened, such as
+// on a machine without a display.
+func requireWindow(b *testing.B) {
+	if testing.Short() {
+		b.Skip("Skipping drawing benchmark in short mode")
+	}
+	runtime.LockOSThread()
+	if windowContext == nil && windowErr == nil {
+		var context *twodee.Context
+		if context, windowErr = twodee.NewContext(); windowErr == nil {
+			if windowErr = context.CreateWindow(benchmarkWindowSize, benchmarkWindowSize, "benchmarks"); windowErr != nil {
+				context.Delete()
+			} else {
+				windowContext = context
+			}
+		}
+	}
+	if windowErr != nil {
+		b.Skipf("Could not open a window: %v", windowErr)
+	}
+	windowContext.Window.MakeContextCurrent()
+}
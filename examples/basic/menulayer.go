@@ -77,6 +77,18 @@ func NewMenuLayer(winb twodee.Rectangle, state *State, app *Application) (layer
 			twodee.NewBoundValueMenuItem("2048", 2048, &state.ObjectCount),
 			twodee.NewBoundValueMenuItem("4096", 4096, &state.ObjectCount),
 		}),
+		twodee.NewParentMenuItem("Speed", []twodee.MenuItem{
+			twodee.NewBackMenuItem(".."),
+			twodee.NewBoundValueMenuItem("2", 2, &state.PlayerSpeed),
+			twodee.NewBoundValueMenuItem("4", 4, &state.PlayerSpeed),
+			twodee.NewBoundValueMenuItem("8", 8, &state.PlayerSpeed),
+			twodee.NewBoundValueMenuItem("16", 16, &state.PlayerSpeed),
+		}),
+		twodee.NewParentMenuItem("Paths", []twodee.MenuItem{
+			twodee.NewBackMenuItem(".."),
+			twodee.NewBoundValueMenuItem("4 way", 0, &state.PathDiagonal),
+			twodee.NewBoundValueMenuItem("8 way", 1, &state.PathDiagonal),
+		}),
 		twodee.NewKeyValueMenuItem("Fullscreen", ProgramCode, FullscreenCode),
 		twodee.NewKeyValueMenuItem("Exit", ProgramCode, ExitCode),
 	})
@@ -0,0 +1,299 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	twodee "../../libs/twodee"
+	"fmt"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/kurrik/tmxgo"
+	"image"
+	"image/color"
+	"math"
+	"time"
+)
+
+const (
+	// Largest side of the minimap, and its distance from the window's
+	// top right corner, in window pixels.
+	MinimapSize   = 160
+	MinimapMargin = 10
+)
+
+var (
+	MinimapWallColor   = color.RGBA{0, 0, 255, 255}
+	MinimapFloorColor  = color.RGBA{160, 160, 160, 255}
+	MinimapEmptyColor  = color.RGBA{0, 0, 0, 255}
+	MinimapPlayerColor = color.RGBA{255, 255, 0, 255}
+	MinimapEnemyColor  = color.RGBA{255, 0, 0, 255}
+	MinimapEntityColor = color.RGBA{0, 255, 0, 255}
+	MinimapCameraColor = color.RGBA{255, 255, 255, 192}
+)
+
+// MinimapLayer shows the whole level in the corner of the window, with the
+// player, entities and what each viewport can see drawn on top.  Clicking it
+// moves the main camera.  Tab hides and shows it.
+type MinimapLayer struct {
+	camera  *twodee.Camera
+	text    *twodee.TextRenderer
+	lines   *twodee.LinesRenderer
+	texture *twodee.Texture
+	game    *GameLayer
+	level   *Level
+	bounds  twodee.Rectangle
+	area    twodee.Rectangle
+	visible bool
+	mousex  float32
+	mousey  float32
+}
+
+func NewMinimapLayer(winb twodee.Rectangle, game *GameLayer) (layer *MinimapLayer, err error) {
+	layer = &MinimapLayer{
+		game:    game,
+		bounds:  winb,
+		visible: true,
+	}
+	err = layer.Reset()
+	return
+}
+
+func (ml *MinimapLayer) Reset() (err error) {
+	if ml.text != nil {
+		ml.text.Delete()
+	}
+	if ml.lines != nil {
+		ml.lines.Delete()
+	}
+	// Both bounds same, so that world units are window pixels.
+	if ml.camera, err = twodee.NewCamera(ml.bounds, ml.bounds); err != nil {
+		return
+	}
+	if ml.text, err = twodee.NewTextRenderer(ml.camera); err != nil {
+		return
+	}
+	if ml.lines, err = twodee.NewLinesRenderer(ml.camera); err != nil {
+		return
+	}
+	// Rebuild the texture on the next update.
+	ml.level = nil
+	return
+}
+
+func (ml *MinimapLayer) Delete() {
+	ml.text.Delete()
+	ml.lines.Delete()
+	if ml.texture != nil {
+		ml.texture.Delete()
+	}
+}
+
+// GetMinimapImage draws a level at no more than size pixels on a side.  Each
+// pixel takes the colour of the cell under it: walls from the collision
+// grid, floor shaded darker the more it costs to cross, and empty cells where
+// no visible tile layer has a tile.
+func GetMinimapImage(level *Level, size int) (img *image.RGBA, err error) {
+	var (
+		grid  = level.Collision.Grid
+		tiled = make([]bool, grid.Width*grid.Height)
+		tiles []*tmxgo.Tile
+		scale = float32(size) / float32(grid.Width)
+	)
+	if grid.Height > grid.Width {
+		scale = float32(size) / float32(grid.Height)
+	}
+	for _, layer := range level.Layers {
+		if tiles, err = level.Map.TilesFromLayerName(layer.Name); err != nil {
+			return
+		}
+		for i, t := range tiles {
+			if t != nil {
+				tiled[i] = true
+			}
+		}
+	}
+	var (
+		w = int(math.Ceil(float64(float32(grid.Width) * scale)))
+		h = int(math.Ceil(float64(float32(grid.Height) * scale)))
+	)
+	img = image.NewRGBA(image.Rect(0, 0, w, h))
+	for py := 0; py < h; py++ {
+		for px := 0; px < w; px++ {
+			var (
+				x = int32(float32(px) / scale)
+				y = int32(float32(py) / scale)
+				i = y*grid.Width + x
+			)
+			switch {
+			case grid.GetIndex(i):
+				img.Set(px, py, MinimapWallColor)
+			case !tiled[i]:
+				img.Set(px, py, MinimapEmptyColor)
+			default:
+				img.Set(px, py, minimapFloor(level.Costs.Costs[i]))
+			}
+		}
+	}
+	return
+}
+
+// minimapFloor darkens the floor colour for costly cells.
+func minimapFloor(cost float32) color.RGBA {
+	if cost <= 1 {
+		return MinimapFloorColor
+	}
+	var c = MinimapFloorColor
+	c.R = uint8(float32(c.R) / cost)
+	c.G = uint8(float32(c.G) / cost)
+	c.B = uint8(float32(c.B) / cost)
+	return c
+}
+
+// rebuild replaces the texture with one of the current level.
+func (ml *MinimapLayer) rebuild(level *Level) (err error) {
+	var img *image.RGBA
+	if img, err = GetMinimapImage(level, MinimapSize); err != nil {
+		return
+	}
+	if ml.texture != nil {
+		ml.texture.Delete()
+	}
+	if ml.texture, err = twodee.GetTexture(img, twodee.Nearest); err != nil {
+		return
+	}
+	var (
+		w = float32(img.Bounds().Dx())
+		h = float32(img.Bounds().Dy())
+	)
+	ml.area = twodee.Rect(
+		ml.bounds.Max.X-MinimapMargin-w,
+		ml.bounds.Max.Y-MinimapMargin-h,
+		ml.bounds.Max.X-MinimapMargin,
+		ml.bounds.Max.Y-MinimapMargin,
+	)
+	return
+}
+
+// WorldToMinimap converts a world point to window pixels on the minimap.
+func (ml *MinimapLayer) WorldToMinimap(pt twodee.Point) twodee.Point {
+	var world = ml.level.Bounds()
+	return twodee.Pt(
+		ml.area.Min.X+(pt.X-world.Min.X)/(world.Max.X-world.Min.X)*(ml.area.Max.X-ml.area.Min.X),
+		ml.area.Min.Y+(pt.Y-world.Min.Y)/(world.Max.Y-world.Min.Y)*(ml.area.Max.Y-ml.area.Min.Y),
+	)
+}
+
+// MinimapToWorld is the reverse of WorldToMinimap.
+func (ml *MinimapLayer) MinimapToWorld(pt twodee.Point) twodee.Point {
+	var world = ml.level.Bounds()
+	return twodee.Pt(
+		world.Min.X+(pt.X-ml.area.Min.X)/(ml.area.Max.X-ml.area.Min.X)*(world.Max.X-world.Min.X),
+		world.Min.Y+(pt.Y-ml.area.Min.Y)/(ml.area.Max.Y-ml.area.Min.Y)*(world.Max.Y-world.Min.Y),
+	)
+}
+
+func (ml *MinimapLayer) Render() {
+	if !ml.visible || ml.texture == nil {
+		return
+	}
+	ml.text.Bind()
+	ml.text.Draw(ml.texture, ml.area.Min.X, ml.area.Min.Y)
+	ml.text.Unbind()
+
+	ml.lines.Bind()
+	for _, vp := range ml.game.viewports {
+		var (
+			view = vp.Camera.WorldBounds
+			min  = ml.WorldToMinimap(view.Min)
+			max  = ml.WorldToMinimap(view.Max)
+		)
+		ml.drawLine([]mgl32.Vec2{
+			mgl32.Vec2{min.X, min.Y},
+			mgl32.Vec2{max.X, min.Y},
+			mgl32.Vec2{max.X, max.Y},
+			mgl32.Vec2{min.X, max.Y},
+		}, true, 1, MinimapCameraColor)
+	}
+	for _, e := range ml.game.entities {
+		var c = MinimapEntityColor
+		if e.Object.Type == "enemy" {
+			c = MinimapEnemyColor
+		}
+		ml.drawDot(ml.game.world.Transforms[e.ID.Index()].Pos, 3, c)
+	}
+	ml.drawDot(ml.game.world.Interpolated(ml.game.player.ID, ml.game.app.Alpha).Pos, 4, MinimapPlayerColor)
+	ml.lines.Unbind()
+}
+
+func (ml *MinimapLayer) drawDot(pt twodee.Point, size float32, c color.RGBA) {
+	var p = ml.WorldToMinimap(pt)
+	ml.drawLine([]mgl32.Vec2{
+		mgl32.Vec2{p.X - size/2, p.Y},
+		mgl32.Vec2{p.X + size/2, p.Y},
+	}, false, size, c)
+}
+
+func (ml *MinimapLayer) drawLine(points []mgl32.Vec2, closed bool, thickness float32, c color.RGBA) {
+	var style = &twodee.LineStyle{
+		Thickness: thickness,
+		Color:     c,
+		Inner:     0.0,
+	}
+	ml.lines.Draw(twodee.NewLineGeometry(points, closed), mgl32.Ident4(), style)
+}
+
+func (ml *MinimapLayer) Update(elapsed time.Duration) {
+	var level = ml.game.levels.Current
+	if level == nil || level == ml.level {
+		return
+	}
+	if err := ml.rebuild(level); err != nil {
+		fmt.Printf("Problem drawing minimap: %v\n", err)
+		return
+	}
+	ml.level = level
+}
+
+// contains reports whether a window point, measured from the top left like
+// mouse events, is on the minimap.
+func (ml *MinimapLayer) contains(x, y float32) bool {
+	var gly = ml.bounds.Max.Y - y
+	return ml.visible && ml.level != nil &&
+		x >= ml.area.Min.X && x < ml.area.Max.X &&
+		gly >= ml.area.Min.Y && gly < ml.area.Max.Y
+}
+
+func (ml *MinimapLayer) HandleEvent(evt twodee.Event) bool {
+	switch event := evt.(type) {
+	case *twodee.MouseMoveEvent:
+		ml.mousex, ml.mousey = event.X, event.Y
+	case *twodee.MouseButtonEvent:
+		if !ml.contains(ml.mousex, ml.mousey) {
+			break
+		}
+		if event.Type == twodee.Press {
+			var pt = ml.MinimapToWorld(twodee.Pt(ml.mousex, ml.bounds.Max.Y-ml.mousey))
+			ml.game.viewports[0].Follow.LookAt(pt)
+		}
+		// Keep the click from also walking the player.
+		return false
+	case *MouseWheelEvent:
+		return !ml.contains(ml.mousex, ml.mousey)
+	case *twodee.KeyEvent:
+		if event.Type == twodee.Press && event.Code == twodee.KeyTab {
+			ml.visible = !ml.visible
+		}
+	}
+	return true
+}
@@ -0,0 +1,214 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	twodee "../../libs/twodee"
+	"fmt"
+	"time"
+)
+
+const (
+	// Objects of this type become trigger regions instead of entities.
+	TriggerObjectType = "trigger"
+
+	// Trigger properties naming script events to fire through
+	// Scripting.TriggerEvent when the player enters or leaves.
+	TriggerEnterScriptProperty = "onenter"
+	TriggerLeaveScriptProperty = "onleave"
+
+	// Entity property naming the spritesheet frame to draw.
+	EntitySpriteProperty = "sprite"
+)
+
+// LevelObject is a map object converted to world coordinates.
+type LevelObject struct {
+	Group      string
+	Name       string
+	Type       string
+	Bounds     twodee.Rectangle
+	Properties map[string]string
+}
+
+// Center returns the middle of the object's bounds.
+func (o *LevelObject) Center() twodee.Point {
+	return twodee.Pt(
+		(o.Bounds.Min.X+o.Bounds.Max.X)/2,
+		(o.Bounds.Min.Y+o.Bounds.Max.Y)/2,
+	)
+}
+
+func (o *LevelObject) Contains(pt twodee.Point) bool {
+	return pt.X >= o.Bounds.Min.X && pt.X < o.Bounds.Max.X &&
+		pt.Y >= o.Bounds.Min.Y && pt.Y < o.Bounds.Max.Y
+}
+
+// LevelEntity is an entity spawned from a map object.  Spawn adds it to a
+// World, where the systems update it along with everything else.
+type LevelEntity struct {
+	Object *LevelObject
+	Sprite string
+	Pos    twodee.Point
+	Size   twodee.Point
+
+	// ID is the entity in the World once it has been spawned.
+	ID EntityID
+}
+
+// Spawn adds the entity to a world, drawn with frame and with a collider the
+// size of the object.
+func (e *LevelEntity) Spawn(w *World, frame FrameHandle) {
+	e.ID = w.Create()
+	w.SetTransform(e.ID, Transform{Pos: e.Pos})
+	w.SetSprite(e.ID, Sprite{Layer: SpriteLayerActors, Frame: frame})
+	w.SetCollider(e.ID, Collider{Width: e.Size.X, Height: e.Size.Y})
+}
+
+// EntityConstructor builds the entity for a map object.  Constructors may
+// return a nil entity if the object only configures something that already
+// exists, such as the player's start position.
+type EntityConstructor func(obj *LevelObject) (*LevelEntity, error)
+
+// EntityRegistry maps object types to the constructors that spawn them.
+type EntityRegistry struct {
+	constructors map[string]EntityConstructor
+}
+
+func NewEntityRegistry() *EntityRegistry {
+	return &EntityRegistry{
+		constructors: map[string]EntityConstructor{},
+	}
+}
+
+func (r *EntityRegistry) Register(objType string, constructor EntityConstructor) {
+	r.constructors[objType] = constructor
+}
+
+// Spawn builds entities for every object with a registered type.  Objects of
+// other types are skipped.
+func (r *EntityRegistry) Spawn(objects []*LevelObject) (entities []*LevelEntity, err error) {
+	var entity *LevelEntity
+	for _, obj := range objects {
+		constructor, ok := r.constructors[obj.Type]
+		if !ok {
+			continue
+		}
+		if entity, err = constructor(obj); err != nil {
+			err = fmt.Errorf("Could not spawn %v %v: %v", obj.Type, obj.Name, err)
+			return
+		}
+		if entity != nil {
+			entities = append(entities, entity)
+		}
+	}
+	return
+}
+
+// NewSpriteEntityConstructor returns a constructor for entities which just
+// draw a single frame, by default the one given here or else the object's
+// "sprite" property.  Point objects are one unit across.
+func NewSpriteEntityConstructor(sprite string) EntityConstructor {
+	return func(obj *LevelObject) (entity *LevelEntity, err error) {
+		var (
+			center = obj.Center()
+			w      = obj.Bounds.Max.X - obj.Bounds.Min.X
+			h      = obj.Bounds.Max.Y - obj.Bounds.Min.Y
+		)
+		if w == 0 || h == 0 {
+			w, h = 1, 1
+		}
+		entity = &LevelEntity{
+			Object: obj,
+			Sprite: sprite,
+			Pos:    center,
+			Size:   twodee.Pt(w, h),
+		}
+		if s, ok := obj.Properties[EntitySpriteProperty]; ok {
+			entity.Sprite = s
+		}
+		return
+	}
+}
+
+// TriggerEvent is sent through the GameEventHandler with the TriggerEnter
+// and TriggerLeave types.
+type TriggerEvent struct {
+	*twodee.BasicGameEvent
+	Trigger *Trigger
+	Entity  twodee.Entity
+}
+
+// Trigger is a region of the map which fires events as an entity moves in
+// and out of it.
+type Trigger struct {
+	Object *LevelObject
+	inside bool
+}
+
+// TriggerSystem watches a single entity against every trigger in a level.
+type TriggerSystem struct {
+	Triggers []*Trigger
+	Entity   twodee.Entity
+	events   *twodee.GameEventHandler
+	script   *twodee.Scripting
+}
+
+func NewTriggerSystem(objects []*LevelObject, entity twodee.Entity, events *twodee.GameEventHandler, script *twodee.Scripting) *TriggerSystem {
+	var ts = &TriggerSystem{
+		Entity: entity,
+		events: events,
+		script: script,
+	}
+	for _, obj := range objects {
+		if obj.Type == TriggerObjectType {
+			ts.Triggers = append(ts.Triggers, &Trigger{Object: obj})
+		}
+	}
+	return ts
+}
+
+// Update fires enter and leave events for any trigger the entity has moved
+// into or out of since the last call.
+func (ts *TriggerSystem) Update(w *World, elapsed time.Duration) {
+	var (
+		entity = ts.Entity
+		pos    = entity.Pos()
+	)
+	for _, t := range ts.Triggers {
+		var inside = t.Object.Contains(pos)
+		if inside == t.inside {
+			continue
+		}
+		t.inside = inside
+		if inside {
+			ts.fire(t, entity, TriggerEnter, TriggerEnterScriptProperty)
+		} else {
+			ts.fire(t, entity, TriggerLeave, TriggerLeaveScriptProperty)
+		}
+	}
+}
+
+func (ts *TriggerSystem) fire(t *Trigger, entity twodee.Entity, eventType twodee.GameEventType, property string) {
+	ts.events.Enqueue(&TriggerEvent{
+		BasicGameEvent: twodee.NewBasicGameEvent(eventType),
+		Trigger:        t,
+		Entity:         entity,
+	})
+	if name, ok := t.Object.Properties[property]; ok && ts.script != nil {
+		if err := ts.script.TriggerEvent(name, entity, t.Object); err != nil {
+			fmt.Printf("Problem triggering event: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	twodee "../../libs/twodee"
+	"fmt"
+	"math"
+	"time"
+)
+
+// WorldToGrid returns the grid cell, with row 0 at the top of the map, which
+// contains a world point.
+func (c *CollisionGrid) WorldToGrid(pt twodee.Point) (x, y int32) {
+	var col, row = c.WorldToTile(pt.X, pt.Y)
+	return col, c.Grid.Height - 1 - row
+}
+
+// GridToWorld returns the world coordinates of the center of a grid cell.
+func (c *CollisionGrid) GridToWorld(x, y int32) twodee.Point {
+	var wx, wy = c.TileToWorld(x, c.Grid.Height-1-y)
+	return twodee.Pt(wx, wy)
+}
+
+// GetPath finds the cheapest path between two world points over a cost grid
+// the size of this one, and returns the centers of the cells along it,
+// starting after the cell containing from.
+func (c *CollisionGrid) GetPath(from, to twodee.Point, costs *CostGrid, opts PathOptions) (path []twodee.Point, err error) {
+	var (
+		x1, y1 = c.WorldToGrid(from)
+		x2, y2 = c.WorldToGrid(to)
+		cells  []twodee.Point
+	)
+	if x2 < 0 || y2 < 0 || x2 >= c.Grid.Width || y2 >= c.Grid.Height {
+		err = fmt.Errorf("Point %v is outside the map", to)
+		return
+	}
+	if cells, err = costs.FindPath(x1, y1, x2, y2, opts); err != nil {
+		return
+	}
+	path = make([]twodee.Point, 0, len(cells))
+	for _, cell := range cells {
+		if int32(cell.X) == x1 && int32(cell.Y) == y1 {
+			continue
+		}
+		path = append(path, c.GridToWorld(int32(cell.X), int32(cell.Y)))
+	}
+	return
+}
+
+// PathArriveDistance is how close, in world units, an entity must come to
+// where it was steered for the waypoints on the way to count as reached.
+const PathArriveDistance float32 = 0.001
+
+// PathStuckFrames is how many updates in a row an entity may fail to get any
+// closer to its next waypoint before the follower gives up on it.
+const PathStuckFrames = 30
+
+// PathFollower steers an entity through a list of world space waypoints by
+// setting its velocity, so that it moves up to Speed world units a second
+// along the path.
+//
+// An entity which stops getting closer to its next waypoint, because a
+// collider keeps clipping its moves, is stuck.  The follower then asks
+// Replan for a new path from where the entity is, or without Replan skips to
+// the waypoint after.  If that fails, or the entity is stuck again before it
+// makes any progress, the path is cleared.
+type PathFollower struct {
+	Entity EntityID
+	Speed  float32
+	Replan func(from twodee.Point) ([]twodee.Point, error)
+	path   []twodee.Point
+	next   int
+
+	// Where the last update steered the entity, and the next waypoint once
+	// it gets there.
+	target  twodee.Point
+	planned int
+
+	// The waypoint progress is measured toward, the nearest the entity has
+	// come to it, how many updates since it got any nearer, and whether the
+	// path was replanned since the entity last made progress.
+	closest   int
+	best      float32
+	stuck     int
+	replanned bool
+}
+
+func NewPathFollower(entity EntityID) *PathFollower {
+	return &PathFollower{Entity: entity, closest: -1}
+}
+
+// SetPath replaces the path being followed.  A nil path stops the entity.
+func (pf *PathFollower) SetPath(path []twodee.Point) {
+	pf.path = path
+	pf.next = 0
+	pf.planned = 0
+	pf.closest = -1
+	pf.stuck = 0
+	pf.replanned = false
+}
+
+func (pf *PathFollower) Active() bool {
+	return pf.next < len(pf.path)
+}
+
+// Remaining returns the waypoints which have not been reached yet.
+func (pf *PathFollower) Remaining() []twodee.Point {
+	return pf.path[pf.next:]
+}
+
+// Update sets the entity's velocity to reach the point it should be at
+// along the path after elapsed, passing any waypoints on the way.  Waypoints
+// only count as reached once the entity has actually arrived where it was
+// steered, since a collider may stop it short.
+func (pf *PathFollower) Update(w *World, elapsed time.Duration) {
+	var (
+		dt     = float32(elapsed.Seconds())
+		budget = pf.Speed * dt
+		start  = w.Transforms[pf.Entity.Index()].Pos
+		pos    = start
+		i      int
+	)
+	if pf.planned > pf.next && pathDistance(start, pf.target) <= PathArriveDistance {
+		pf.next = pf.planned
+	}
+	if budget > 0 && pf.Active() && pf.isStuck(start) {
+		pf.unstick(start)
+	}
+	for i = pf.next; budget > 0 && i < len(pf.path); {
+		var (
+			goal = pf.path[i]
+			dist = pathDistance(pos, goal)
+		)
+		if dist <= budget {
+			pos = goal
+			i++
+			budget -= dist
+			continue
+		}
+		pos = twodee.Pt(pos.X+(goal.X-pos.X)/dist*budget, pos.Y+(goal.Y-pos.Y)/dist*budget)
+		budget = 0
+	}
+	pf.target = pos
+	pf.planned = i
+	var v Velocity
+	if dt > 0 {
+		v = Velocity{(pos.X - start.X) / dt, (pos.Y - start.Y) / dt}
+	}
+	w.SetVelocity(pf.Entity, v)
+}
+
+// isStuck records how close pos is to the next waypoint, and reports whether
+// the entity has gone PathStuckFrames updates without getting any closer.
+func (pf *PathFollower) isStuck(pos twodee.Point) bool {
+	var dist = pathDistance(pos, pf.path[pf.next])
+	if pf.closest != pf.next || dist < pf.best-PathArriveDistance {
+		// Only a fresh path keeps its replan, until it makes progress.
+		pf.replanned = pf.replanned && pf.closest < 0
+		pf.closest = pf.next
+		pf.best = dist
+		pf.stuck = 0
+		return false
+	}
+	pf.stuck++
+	return pf.stuck >= PathStuckFrames
+}
+
+// unstick replans or skips a waypoint, clearing the path if neither helps.
+func (pf *PathFollower) unstick(from twodee.Point) {
+	if pf.Replan == nil {
+		if pf.next+1 < len(pf.path) {
+			pf.next++
+			pf.planned = pf.next
+			return
+		}
+	} else if !pf.replanned {
+		if path, err := pf.Replan(from); err == nil && len(path) > 0 {
+			pf.SetPath(path)
+			pf.replanned = true
+			return
+		}
+	}
+	pf.SetPath(nil)
+}
+
+func pathDistance(a, b twodee.Point) float32 {
+	var dx, dy = b.X - a.X, b.Y - a.Y
+	return float32(math.Sqrt(float64(dx*dx + dy*dy)))
+}
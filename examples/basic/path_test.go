@@ -0,0 +1,95 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	twodee "../../libs/twodee"
+	"errors"
+	"testing"
+)
+
+// TestPathFollowerStuck holds an entity still, as a wall would, and checks
+// that the follower skips waypoints or replans before giving up.
+func TestPathFollowerStuck(t *testing.T) {
+	var (
+		path    = []twodee.Point{twodee.Pt(1, 0), twodee.Pt(2, 0)}
+		replans int
+		tests   = []struct {
+			name    string
+			replan  func(from twodee.Point) ([]twodee.Point, error)
+			updates int
+			next    int
+			active  bool
+			replans int
+		}{
+			{"skip", nil, PathStuckFrames + 1, 1, true, 0},
+			{"skip to the end", nil, 2 * (PathStuckFrames + 1), 0, false, 0},
+			{"replan", func(from twodee.Point) ([]twodee.Point, error) {
+				replans++
+				return []twodee.Point{twodee.Pt(0, 1)}, nil
+			}, PathStuckFrames + 1, 0, true, 1},
+			{"replan again", func(from twodee.Point) ([]twodee.Point, error) {
+				replans++
+				return []twodee.Point{twodee.Pt(0, 1)}, nil
+			}, 2 * (PathStuckFrames + 1), 0, false, 1},
+			{"replan fails", func(from twodee.Point) ([]twodee.Point, error) {
+				replans++
+				return nil, errors.New("No path")
+			}, PathStuckFrames + 1, 0, false, 1},
+		}
+	)
+	for _, test := range tests {
+		var (
+			w  = NewWorld(1)
+			id = w.Create()
+			pf = NewPathFollower(id)
+		)
+		replans = 0
+		w.SetTransform(id, Transform{})
+		pf.Speed = 1
+		pf.Replan = test.replan
+		pf.SetPath(path)
+		// The entity never moves, so it is stuck PathStuckFrames updates after
+		// the first toward each waypoint.
+		for i := 0; i < test.updates; i++ {
+			pf.Update(w, twodee.Step60Hz)
+		}
+		if pf.Active() != test.active || (pf.Active() && pf.next != test.next) || replans != test.replans {
+			t.Errorf("%v: active %v at waypoint %v after %v replans, want %v at %v after %v",
+				test.name, pf.Active(), pf.next, replans, test.active, test.next, test.replans)
+		}
+	}
+}
+
+// TestPathFollowerProgress checks that an entity which keeps moving is never
+// treated as stuck, however long the path takes.
+func TestPathFollowerProgress(t *testing.T) {
+	var (
+		w        = NewWorld(1)
+		id       = w.Create()
+		pf       = NewPathFollower(id)
+		movement = &MovementSystem{}
+	)
+	w.SetTransform(id, Transform{})
+	pf.Speed = 1
+	pf.SetPath([]twodee.Point{twodee.Pt(1, 0), twodee.Pt(1, 1)})
+	for i := 0; i < 3*60 && pf.Active(); i++ {
+		pf.Update(w, twodee.Step60Hz)
+		movement.Update(w, twodee.Step60Hz)
+	}
+	if pos := w.Transforms[id.Index()].Pos; pathDistance(pos, twodee.Pt(1, 1)) > PathArriveDistance {
+		t.Fatalf("Entity stopped at %v", pos)
+	}
+}
@@ -14,13 +14,17 @@ aseprite \
   --batch assets/originals/numbered_squares_wide.ase \
   --save-as tmp/numbered_squares_wide_01.png
 
-TexturePacker \
-  --data assets/textures/spritesheet.json \
+aseprite \
+  --batch assets/originals/numbered_squares.ase \
+  --sheet assets/textures/player.png \
+  --data assets/textures/player.json \
   --format json-array \
-  --trim-sprite-names \
-  --size-constraints POT \
-  --disable-rotation \
-  --sheet assets/textures/spritesheet.png \
+  --list-tags \
+  --filename-format '{title}_{frame01}'
+
+go run ../atlaspack/main.go \
+  -out assets/textures/spritesheet \
+  -rotate \
   tmp
 
 rm -rf tmp
@@ -0,0 +1,234 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	twodee "../../libs/twodee"
+	"github.com/go-gl/mathgl/mgl32"
+	"math"
+	"time"
+)
+
+// ShakeEvent asks for the screen to shake.  It is sent through the
+// GameEventHandler with the Shake type, so anything can shake the screen
+// without a reference to the layer drawing it.
+type ShakeEvent struct {
+	*twodee.BasicGameEvent
+	Source string
+	Trauma float32
+}
+
+func NewShakeEvent(source string, trauma float32) *ShakeEvent {
+	return &ShakeEvent{
+		BasicGameEvent: twodee.NewBasicGameEvent(Shake),
+		Source:         source,
+		Trauma:         trauma,
+	}
+}
+
+// ScreenShake turns trauma into a camera offset and roll.  Trauma runs from
+// 0 to 1, stacks as more is added and wears off over time.  The shake scales
+// with trauma squared, so small knocks barely register while big ones are
+// violent, and follows smooth noise so that it jitters without jumping.
+type ScreenShake struct {
+	// Offset and roll at full trauma, in world units and radians.
+	MaxOffset float32
+	MaxRoll   float32
+
+	// Frequency is how many noise samples are passed each second.
+	Frequency float32
+
+	// Decay is how much trauma wears off each second.
+	Decay float32
+
+	// Limits caps how much trauma each source can hold at once, so that
+	// repeated small hits cannot add up to an explosion.  Sources without
+	// a limit may add up to full trauma.
+	Limits map[string]float32
+
+	trauma  float32
+	sources map[string]float32
+	time    float32
+}
+
+func NewScreenShake(maxOffset, maxRoll, frequency, decay float32) *ScreenShake {
+	return &ScreenShake{
+		MaxOffset: maxOffset,
+		MaxRoll:   maxRoll,
+		Frequency: frequency,
+		Decay:     decay,
+		Limits:    map[string]float32{},
+		sources:   map[string]float32{},
+	}
+}
+
+// Add adds trauma from a source, up to the source's limit.
+func (s *ScreenShake) Add(source string, trauma float32) {
+	var (
+		limit, ok = s.Limits[source]
+		current   = s.sources[source]
+		next      = current + trauma
+	)
+	if !ok || limit > 1 {
+		limit = 1
+	}
+	if next > limit {
+		next = limit
+	}
+	if next <= current {
+		return
+	}
+	s.sources[source] = next
+	if s.trauma += next - current; s.trauma > 1 {
+		s.trauma = 1
+	}
+}
+
+func (s *ScreenShake) Trauma() float32 {
+	return s.trauma
+}
+
+func (s *ScreenShake) Update(elapsed time.Duration) {
+	var (
+		dt    = float32(elapsed.Seconds())
+		decay = s.Decay * dt
+	)
+	s.time += dt
+	if s.trauma = s.trauma - decay; s.trauma < 0 {
+		s.trauma = 0
+	}
+	for source, trauma := range s.sources {
+		if trauma -= decay; trauma <= 0 {
+			delete(s.sources, source)
+		} else {
+			s.sources[source] = trauma
+		}
+	}
+}
+
+// Offset returns how far to move the camera and how far to roll it.
+func (s *ScreenShake) Offset() (x, y, roll float32) {
+	if s.trauma == 0 {
+		return
+	}
+	var (
+		amount = s.trauma * s.trauma
+		t      = s.time * s.Frequency
+	)
+	x = s.MaxOffset * amount * shakeNoise(0, t)
+	y = s.MaxOffset * amount * shakeNoise(1, t)
+	roll = s.MaxRoll * amount * shakeNoise(2, t)
+	return
+}
+
+// shakeNoise is 1D gradient noise, between about -1 and 1, with a separate
+// pattern for each channel.
+func shakeNoise(channel uint32, t float32) float32 {
+	var (
+		i  = float32(math.Floor(float64(t)))
+		f  = t - i
+		g0 = shakeGradient(channel, int32(i))
+		g1 = shakeGradient(channel, int32(i)+1)
+		u  = f * f * (3 - 2*f)
+	)
+	return 2 * ((1-u)*g0*f + u*g1*(f-1))
+}
+
+// shakeGradient hashes a lattice point to a slope between -1 and 1.
+func shakeGradient(channel uint32, i int32) float32 {
+	var h = uint32(i)*0x9e3779b1 ^ channel*0x85ebca6b
+	h ^= h >> 16
+	h *= 0x7feb352d
+	h ^= h >> 15
+	h *= 0x846ca68b
+	h ^= h >> 16
+	return float32(h)/float32(math.MaxUint32)*2 - 1
+}
+
+// Roll turns the scene by Angle radians around Center.  twodee.Camera can
+// only pan, so each renderer applies the roll through its own transform.
+type Roll struct {
+	Center twodee.Point
+	Angle  float32
+}
+
+// Point returns where a world point is drawn once rolled.
+func (r Roll) Point(pt twodee.Point) twodee.Point {
+	return r.rotate(pt, r.Angle)
+}
+
+// Unroll undoes Point, for turning a position on screen back into the world
+// point drawn there.
+func (r Roll) Unroll(pt twodee.Point) twodee.Point {
+	return r.rotate(pt, -r.Angle)
+}
+
+func (r Roll) rotate(pt twodee.Point, angle float32) twodee.Point {
+	if angle == 0 {
+		return pt
+	}
+	var (
+		sin = float32(math.Sin(float64(angle)))
+		cos = float32(math.Cos(float64(angle)))
+		dx  = pt.X - r.Center.X
+		dy  = pt.Y - r.Center.Y
+	)
+	return twodee.Pt(r.Center.X+dx*cos-dy*sin, r.Center.Y+dx*sin+dy*cos)
+}
+
+// Covering returns the world area which ends up inside rect once rolled.
+func (r Roll) Covering(rect twodee.Rectangle) twodee.Rectangle {
+	if r.Angle == 0 {
+		return rect
+	}
+	var (
+		corners = []twodee.Point{
+			r.Unroll(rect.Min),
+			r.Unroll(rect.Max),
+			r.Unroll(twodee.Pt(rect.Min.X, rect.Max.Y)),
+			r.Unroll(twodee.Pt(rect.Max.X, rect.Min.Y)),
+		}
+		covered = twodee.Rectangle{Min: corners[0], Max: corners[0]}
+	)
+	for _, c := range corners[1:] {
+		covered.Min.X = float32(math.Min(float64(covered.Min.X), float64(c.X)))
+		covered.Min.Y = float32(math.Min(float64(covered.Min.Y), float64(c.Y)))
+		covered.Max.X = float32(math.Max(float64(covered.Max.X), float64(c.X)))
+		covered.Max.Y = float32(math.Max(float64(covered.Max.Y), float64(c.Y)))
+	}
+	return covered
+}
+
+// Sprites rolls sprite configs in place.
+func (r Roll) Sprites(configs []twodee.SpriteConfig) {
+	if r.Angle == 0 {
+		return
+	}
+	for i := range configs {
+		var (
+			view = &configs[i].View
+			pt   = r.Point(twodee.Pt(view.X, view.Y))
+		)
+		view.X, view.Y = pt.X, pt.Y
+		view.RotationZ += r.Angle
+	}
+}
+
+// Mat4 returns the roll as a model view matrix.
+func (r Roll) Mat4() mgl32.Mat4 {
+	return mgl32.Translate3D(r.Center.X, r.Center.Y, 0).
+		Mul4(mgl32.HomogRotate3DZ(r.Angle)).
+		Mul4(mgl32.Translate3D(-r.Center.X, -r.Center.Y, 0))
+}
@@ -0,0 +1,271 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	twodee "../../libs/twodee"
+	"math"
+	"sort"
+)
+
+// Sprite layers, drawn from first to last.  Within a layer, sprites further
+// up the screen are drawn first so that nearer ones overlap them.
+const (
+	SpriteLayerSwarm = iota
+	SpriteLayerActors
+)
+
+// FrameHandle picks out a frame registered with a SpriteQueue.  Resolving
+// frames to handles up front keeps name lookups out of the draw loop.
+type FrameHandle int32
+
+// NoFrame is the handle of a frame which could not be found.  Sprites pushed
+// with it are ignored.
+const NoFrame FrameHandle = -1
+
+type spriteFrame struct {
+	texture int
+	radius  float32
+	offset  twodee.Point
+	rotated bool
+	config  twodee.SpritesheetFrameConfig
+}
+
+// place moves a view from the frame's pivot to the centre of the frame, which
+// is where twodee draws it from, turning and scaling the offset along with
+// the sprite.  Frames packed a quarter turn clockwise are turned back, which
+// also swaps which way each scale applies.
+func (f *spriteFrame) place(view twodee.ModelViewConfig) twodee.ModelViewConfig {
+	if f.offset.X != 0 || f.offset.Y != 0 {
+		var (
+			x   = f.offset.X * view.ScaleX
+			y   = f.offset.Y * view.ScaleY
+			sin = float32(math.Sin(float64(view.RotationZ)))
+			cos = float32(math.Cos(float64(view.RotationZ)))
+		)
+		view.X += x*cos - y*sin
+		view.Y += x*sin + y*cos
+	}
+	if f.rotated {
+		view.RotationZ += math.Pi / 2
+		view.ScaleX, view.ScaleY = view.ScaleY, view.ScaleX
+	}
+	return view
+}
+
+type spriteFrameKey struct {
+	texture *twodee.Texture
+	name    string
+}
+
+// SpriteStats counts what a SpriteQueue did with its sprites.
+type SpriteStats struct {
+	Submitted int
+	Culled    int
+	Drawn     int
+	DrawCalls int
+}
+
+type queuedSprite struct {
+	layer   int
+	texture int
+	frame   FrameHandle
+	view    twodee.ModelViewConfig
+}
+
+// SpriteDrawer draws a batch of sprites which all come from texture.
+type SpriteDrawer interface {
+	DrawSprites(texture *twodee.Texture, sprites []twodee.SpriteConfig) error
+}
+
+// TextureDrawer draws sprites with a SpriteRenderer, binding their texture
+// around each batch.
+type TextureDrawer struct {
+	Renderer *twodee.SpriteRenderer
+}
+
+func (d TextureDrawer) DrawSprites(texture *twodee.Texture, sprites []twodee.SpriteConfig) (err error) {
+	texture.Bind()
+	err = d.Renderer.Draw(sprites)
+	texture.Unbind()
+	return
+}
+
+// SpriteQueue collects the sprites drawn through a view, drops those outside
+// it and draws the rest in depth order, with one draw call for each run of
+// sprites sharing a texture.  Its buffers are reused from frame to frame, so
+// once they have grown to fit, queueing and drawing sprites does not allocate.
+type SpriteQueue struct {
+	Drawer SpriteDrawer
+
+	// Stats add up over every flush until they are reset.
+	Stats SpriteStats
+
+	view     twodee.Rectangle
+	roll     Roll
+	sprites  []queuedSprite
+	textures []*twodee.Texture
+	frames   []spriteFrame
+	names    map[spriteFrameKey]FrameHandle
+	configs  []twodee.SpriteConfig
+}
+
+func NewSpriteQueue(drawer SpriteDrawer) *SpriteQueue {
+	return &SpriteQueue{
+		Drawer: drawer,
+		names:  map[spriteFrameKey]FrameHandle{},
+	}
+}
+
+// AddFrame registers a frame of texture and returns its handle.  offset is
+// how far the centre of the frame is from the point sprites are positioned,
+// rotated and scaled by, and rotated is whether the frame is packed a
+// quarter turn clockwise.
+func (q *SpriteQueue) AddFrame(texture *twodee.Texture, frame *twodee.SpritesheetFrame, offset twodee.Point, rotated bool) FrameHandle {
+	q.frames = append(q.frames, spriteFrame{
+		texture: q.textureIndex(texture),
+		// Rotation can swing a corner out as far as half the diagonal.
+		radius: float32(math.Hypot(float64(frame.Width), float64(frame.Height)))/2 +
+			float32(math.Hypot(float64(offset.X), float64(offset.Y))),
+		offset:  offset,
+		rotated: rotated,
+		config:  frame.Frame,
+	})
+	return FrameHandle(len(q.frames) - 1)
+}
+
+// FrameByName returns the handle of a named frame in sheet, registering it
+// the first time it is asked for.  It returns NoFrame if sheet has no such
+// frame.
+func (q *SpriteQueue) FrameByName(sheet *Spritesheet, name string) FrameHandle {
+	var key = spriteFrameKey{sheet.Texture, name}
+	if handle, ok := q.names[key]; ok {
+		return handle
+	}
+	var handle = NoFrame
+	if frame := sheet.GetFrame(name); frame != nil {
+		handle = q.AddFrame(sheet.Texture, frame, sheet.Offsets[name], sheet.Rotated[name])
+	}
+	q.names[key] = handle
+	return handle
+}
+
+// Sprite returns the config for drawing a registered frame directly with a
+// SpriteRenderer, outside the queue.
+func (q *SpriteQueue) Sprite(frame FrameHandle, view twodee.ModelViewConfig) twodee.SpriteConfig {
+	var f = &q.frames[frame]
+	return twodee.SpriteConfig{
+		View:  f.place(view),
+		Frame: f.config,
+	}
+}
+
+// ClearFrames forgets every registered frame, for when textures are
+// reloaded.  Handles returned before are no longer valid.
+func (q *SpriteQueue) ClearFrames() {
+	q.sprites = q.sprites[:0]
+	q.textures = q.textures[:0]
+	q.frames = q.frames[:0]
+	q.names = map[spriteFrameKey]FrameHandle{}
+}
+
+// Begin starts collecting sprites to draw through view, rolled by roll.
+// Anything queued but not flushed is discarded.
+func (q *SpriteQueue) Begin(view twodee.Rectangle, roll Roll) {
+	q.view = roll.Covering(view)
+	q.roll = roll
+	q.sprites = q.sprites[:0]
+}
+
+// Push queues a frame to be drawn with the given view, unless it falls
+// outside the view.
+func (q *SpriteQueue) Push(layer int, frame FrameHandle, view twodee.ModelViewConfig) {
+	if frame == NoFrame {
+		return
+	}
+	q.Stats.Submitted++
+	var (
+		f      = &q.frames[frame]
+		scale  = float32(math.Max(math.Abs(float64(view.ScaleX)), math.Abs(float64(view.ScaleY))))
+		radius = f.radius * scale
+	)
+	if view.X+radius <= q.view.Min.X || view.X-radius >= q.view.Max.X ||
+		view.Y+radius <= q.view.Min.Y || view.Y-radius >= q.view.Max.Y {
+		q.Stats.Culled++
+		return
+	}
+	q.sprites = append(q.sprites, queuedSprite{
+		layer:   layer,
+		texture: f.texture,
+		frame:   frame,
+		view:    view,
+	})
+}
+
+// textureIndex numbers textures in the order they are first registered,
+// which is all sorting needs of them.
+func (q *SpriteQueue) textureIndex(texture *twodee.Texture) int {
+	for i, t := range q.textures {
+		if t == texture {
+			return i
+		}
+	}
+	q.textures = append(q.textures, texture)
+	return len(q.textures) - 1
+}
+
+func (q *SpriteQueue) Len() int {
+	return len(q.sprites)
+}
+
+func (q *SpriteQueue) Less(i, j int) bool {
+	var a, b = &q.sprites[i], &q.sprites[j]
+	if a.layer != b.layer {
+		return a.layer < b.layer
+	}
+	if a.view.Y != b.view.Y {
+		return a.view.Y > b.view.Y
+	}
+	return a.texture < b.texture
+}
+
+func (q *SpriteQueue) Swap(i, j int) {
+	q.sprites[i], q.sprites[j] = q.sprites[j], q.sprites[i]
+}
+
+// Flush sorts and draws the queued sprites, then empties the queue.  The
+// drawer's camera should already show the view given to Begin.
+func (q *SpriteQueue) Flush() (err error) {
+	sort.Stable(q)
+	for start := 0; start < len(q.sprites); {
+		var (
+			texture = q.sprites[start].texture
+			end     = start
+		)
+		q.configs = q.configs[:0]
+		for ; end < len(q.sprites) && q.sprites[end].texture == texture; end++ {
+			q.configs = append(q.configs, q.Sprite(q.sprites[end].frame, q.sprites[end].view))
+		}
+		q.roll.Sprites(q.configs)
+		if err = q.Drawer.DrawSprites(q.textures[texture], q.configs); err != nil {
+			return
+		}
+		q.Stats.Drawn += end - start
+		q.Stats.DrawCalls++
+		start = end
+	}
+	q.sprites = q.sprites[:0]
+	return
+}
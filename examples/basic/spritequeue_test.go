@@ -0,0 +1,145 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	twodee "../../libs/twodee"
+	"reflect"
+	"testing"
+)
+
+// countingDrawer stands in for OpenGL, counting the sprites and batches it is
+// asked to draw.
+type countingDrawer struct {
+	sprites int
+	batches int
+}
+
+func (d *countingDrawer) DrawSprites(texture *twodee.Texture, sprites []twodee.SpriteConfig) error {
+	d.sprites += len(sprites)
+	d.batches++
+	return nil
+}
+
+// TestSpriteQueueFlushAllocs checks that once its buffers have grown,
+// queueing, culling, sorting and drawing a frame of entities does not
+// allocate.  Frames alternate between two textures, and every third is
+// packed rotated, without loading a spritesheet.
+func TestSpriteQueueFlushAllocs(t *testing.T) {
+	const size = 32
+	var (
+		drawer   = &countingDrawer{}
+		queue    = NewSpriteQueue(drawer)
+		textures = []*twodee.Texture{{}, {}}
+		frames   []FrameHandle
+		world    = NewWorld(size * size)
+		view     = twodee.Rect(0, 0, size/2, size/2)
+		roll     = Roll{Center: twodee.Pt(size/4, size/4), Angle: 0.1}
+	)
+	for i := 0; i < NumberedFrames; i++ {
+		frames = append(frames, queue.AddFrame(
+			textures[i%len(textures)],
+			&twodee.SpritesheetFrame{Width: 1, Height: 1},
+			twodee.Point{},
+			i%3 == 0,
+		))
+	}
+	for i := 0; i < size*size; i++ {
+		var id = world.Create()
+		world.SetTransform(id, Transform{
+			Pos:      twodee.Pt(float32(i%size)+0.5, float32(i/size)+0.5),
+			Rotation: float32(i) / 10,
+		})
+		world.SetSprite(id, Sprite{Layer: i % 2, Frame: frames[i%len(frames)]})
+	}
+	draw := func() {
+		queue.Begin(view, roll)
+		PushSprites(world, queue, 1)
+		if err := queue.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if allocs := testing.AllocsPerRun(100, draw); allocs > 0 {
+		t.Fatalf("Sprite queue allocated %v times per frame", allocs)
+	}
+	if queue.Stats.Culled == 0 || drawer.sprites == 0 || drawer.batches < 2 {
+		t.Fatalf("Expected some sprites culled and the rest drawn in batches, got %+v", queue.Stats)
+	}
+}
+
+// recordingDrawer stands in for OpenGL, recording each draw call's texture
+// and the X position of each of its sprites.
+type recordingDrawer struct {
+	textures []*twodee.Texture
+	sprites  [][]float32
+}
+
+func (d *recordingDrawer) DrawSprites(texture *twodee.Texture, sprites []twodee.SpriteConfig) error {
+	var xs []float32
+	for _, s := range sprites {
+		xs = append(xs, s.View.X)
+	}
+	d.textures = append(d.textures, texture)
+	d.sprites = append(d.sprites, xs)
+	return nil
+}
+
+// TestSpriteQueueOrder checks that sprites are drawn by layer, then from the
+// top of the screen down, then by texture, keeping the order they were pushed
+// in otherwise, and that each run sharing a texture is one draw call.
+func TestSpriteQueueOrder(t *testing.T) {
+	var (
+		drawer = &recordingDrawer{}
+		queue  = NewSpriteQueue(drawer)
+		a, b   = &twodee.Texture{}, &twodee.Texture{}
+		frameA = queue.AddFrame(a, &twodee.SpritesheetFrame{Width: 1, Height: 1}, twodee.Point{}, false)
+		frameB = queue.AddFrame(b, &twodee.SpritesheetFrame{Width: 1, Height: 1}, twodee.Point{}, false)
+		pushes = []struct {
+			layer int
+			frame FrameHandle
+			x, y  float32
+		}{
+			{1, frameA, 1, 5},
+			{0, frameB, 2, 2},
+			{0, frameA, 3, 5},
+			{0, frameA, 4, 2},
+			{0, frameB, 5, 2},
+			{1, frameA, 6, 5},
+			{0, frameB, 7, 9},
+			{0, frameA, 50, 5}, // culled
+		}
+		textures = []*twodee.Texture{b, a, b, a}
+		sprites  = [][]float32{{7}, {3, 4}, {2, 5}, {1, 6}}
+	)
+	queue.Begin(twodee.Rect(0, 0, 10, 10), Roll{})
+	for _, p := range pushes {
+		queue.Push(p.layer, p.frame, twodee.ModelViewConfig{X: p.x, Y: p.y, ScaleX: 1, ScaleY: 1})
+	}
+	if err := queue.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(drawer.sprites, sprites) {
+		t.Errorf("Drew sprites %v, want %v", drawer.sprites, sprites)
+	}
+	for i, texture := range drawer.textures {
+		// Compare pointers, as the textures are otherwise identical.
+		if i >= len(textures) || texture != textures[i] {
+			t.Errorf("Draw call %v used the wrong texture", i)
+		}
+	}
+	if want := (SpriteStats{Submitted: 8, Culled: 1, Drawn: 7, DrawCalls: 4}); queue.Stats != want {
+		t.Errorf("Got stats %+v, want %+v", queue.Stats, want)
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	twodee "../../libs/twodee"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// SpritesheetPxPerUnit is how many spritesheet pixels make a world unit.
+const SpritesheetPxPerUnit = 32
+
+// Spritesheet is a twodee.Spritesheet and its texture, plus how to place
+// each frame.  twodee only reads unrotated frames from the JSON array format
+// and draws every frame centred on its position, so the sheet is converted
+// to that form when loaded and Offsets and Rotated record what is needed to
+// put frames back: how far the centre of each packed frame is from its pivot,
+// and which frames TexturePacker turned a quarter turn clockwise to pack.
+// Names lists the frames in the order the sheet gives them.
+type Spritesheet struct {
+	*twodee.Spritesheet
+	Texture *twodee.Texture
+	Names   []string
+	Offsets map[string]twodee.Point
+	Rotated map[string]bool
+}
+
+func (s *Spritesheet) Delete() {
+	s.Texture.Delete()
+}
+
+// GetSpritesheet loads a TexturePacker JSON sheet and its texture.
+func GetSpritesheet(path string) (sheet *Spritesheet, err error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(path); err != nil {
+		return
+	}
+	if sheet, err = ParseSpritesheet(data, SpritesheetPxPerUnit); err != nil {
+		err = fmt.Errorf("Could not parse %v: %v", path, err)
+		return
+	}
+	if sheet.Texture, err = twodee.LoadTexture(filepath.Join(filepath.Dir(path), sheet.TexturePath), twodee.Nearest); err != nil {
+		return
+	}
+	return
+}
+
+type texturePackerRect struct {
+	X float32 `json:"x"`
+	Y float32 `json:"y"`
+	W float32 `json:"w"`
+	H float32 `json:"h"`
+}
+
+type texturePackerFrame struct {
+	Filename         string             `json:"filename"`
+	Frame            texturePackerRect  `json:"frame"`
+	Rotated          bool               `json:"rotated"`
+	Trimmed          bool               `json:"trimmed"`
+	SpriteSourceSize texturePackerRect  `json:"spriteSourceSize"`
+	SourceSize       texturePackerRect  `json:"sourceSize"`
+	Pivot            *texturePackerRect `json:"pivot,omitempty"`
+
+	// Aseprite adds how many milliseconds each frame is shown for.
+	Duration int `json:"duration,omitempty"`
+}
+
+type texturePackerSheet struct {
+	Frames json.RawMessage `json:"frames"`
+	Meta   json.RawMessage `json:"meta"`
+}
+
+// ParseSpritesheet reads a TexturePacker sheet in either the JSON array or
+// the JSON hash format, with or without rotated frames.  It does not load
+// the sheet's texture.
+func ParseSpritesheet(data []byte, pxPerUnit float32) (sheet *Spritesheet, err error) {
+	var (
+		frames []texturePackerFrame
+		meta   json.RawMessage
+		array  []byte
+	)
+	if sheet, frames, meta, err = readTexturePackerSheet(data, pxPerUnit); err != nil {
+		return
+	}
+	if array, err = json.Marshal(struct {
+		Frames []texturePackerFrame `json:"frames"`
+		Meta   json.RawMessage      `json:"meta"`
+	}{frames, meta}); err != nil {
+		return
+	}
+	if sheet.Spritesheet, err = twodee.ParseTexturePackerJSONArrayString(string(array), pxPerUnit); err != nil {
+		return
+	}
+	return
+}
+
+// readTexturePackerSheet does the work of ParseSpritesheet short of handing
+// the sheet to twodee.  It returns the sheet's frames as twodee should read
+// them, unrotated, along with the sheet's names, offsets and rotations.
+func readTexturePackerSheet(data []byte, pxPerUnit float32) (sheet *Spritesheet, frames []texturePackerFrame, meta json.RawMessage, err error) {
+	var doc texturePackerSheet
+	if err = json.Unmarshal(data, &doc); err != nil {
+		return
+	}
+	if frames, err = parseTexturePackerFrames(doc.Frames); err != nil {
+		return
+	}
+	meta = doc.Meta
+	sheet = &Spritesheet{
+		Names:   make([]string, len(frames)),
+		Offsets: map[string]twodee.Point{},
+		Rotated: map[string]bool{},
+	}
+	for i := range frames {
+		var (
+			f     = &frames[i]
+			trim  = f.SpriteSourceSize
+			pivot = texturePackerRect{X: 0.5, Y: 0.5}
+		)
+		sheet.Names[i] = f.Filename
+		if f.Pivot != nil {
+			pivot = *f.Pivot
+		}
+		// TexturePacker measures down from the top, the world measures up.
+		sheet.Offsets[f.Filename] = twodee.Pt(
+			(trim.X+trim.W/2-pivot.X*f.SourceSize.W)/pxPerUnit,
+			-(trim.Y+trim.H/2-pivot.Y*f.SourceSize.H)/pxPerUnit,
+		)
+		// A rotated frame gives its size before rotation.  twodee is given
+		// the area it really covers in the texture, and drawing turns it back.
+		if f.Rotated {
+			sheet.Rotated[f.Filename] = true
+			f.Frame.W, f.Frame.H = f.Frame.H, f.Frame.W
+			f.Rotated = false
+		}
+	}
+	return
+}
+
+// parseTexturePackerFrames reads frames from a JSON array, or from a JSON
+// hash keyed by filename.  Hash frames are kept in the order they are
+// written, since Aseprite refers to frames by their position in the sheet.
+func parseTexturePackerFrames(data json.RawMessage) (frames []texturePackerFrame, err error) {
+	if err = json.Unmarshal(data, &frames); err == nil {
+		return
+	}
+	var (
+		decoder = json.NewDecoder(bytes.NewReader(data))
+		token   json.Token
+	)
+	if token, err = decoder.Token(); err != nil {
+		return
+	}
+	if token != json.Delim('{') {
+		err = fmt.Errorf("Frames are neither a JSON array nor a JSON hash")
+		return
+	}
+	for decoder.More() {
+		var f texturePackerFrame
+		if token, err = decoder.Token(); err != nil {
+			return
+		}
+		if err = decoder.Decode(&f); err != nil {
+			return
+		}
+		f.Filename = token.(string)
+		frames = append(frames, f)
+	}
+	return
+}
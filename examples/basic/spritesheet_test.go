@@ -0,0 +1,102 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	twodee "../../libs/twodee"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// spritesheetTestFrames are the bodies of a sheet's frames, by filename: an
+// untouched frame, a trimmed one, one pivoted at its bottom centre and one
+// packed rotated.
+var spritesheetTestFrames = [][2]string{
+	{"plain", `"frame": {"x": 0, "y": 0, "w": 20, "h": 10}, "rotated": false, "trimmed": false,
+		"spriteSourceSize": {"x": 0, "y": 0, "w": 20, "h": 10}, "sourceSize": {"w": 20, "h": 10}`},
+	{"trimmed", `"frame": {"x": 20, "y": 0, "w": 10, "h": 10}, "rotated": false, "trimmed": true,
+		"spriteSourceSize": {"x": 10, "y": 0, "w": 10, "h": 10}, "sourceSize": {"w": 20, "h": 20}`},
+	{"pivoted", `"frame": {"x": 30, "y": 0, "w": 10, "h": 20}, "rotated": false, "trimmed": false,
+		"spriteSourceSize": {"x": 0, "y": 0, "w": 10, "h": 20}, "sourceSize": {"w": 10, "h": 20},
+		"pivot": {"x": 0.5, "y": 1}`},
+	{"rotated", `"frame": {"x": 40, "y": 0, "w": 20, "h": 10}, "rotated": true, "trimmed": false,
+		"spriteSourceSize": {"x": 0, "y": 0, "w": 20, "h": 10}, "sourceSize": {"w": 20, "h": 10}`},
+}
+
+// spritesheetTestJSON writes spritesheetTestFrames in the JSON array format,
+// or the JSON hash format if hash is set.
+func spritesheetTestJSON(hash bool) string {
+	var frames []string
+	for _, f := range spritesheetTestFrames {
+		if hash {
+			frames = append(frames, fmt.Sprintf(`%q: {%v}`, f[0], f[1]))
+		} else {
+			frames = append(frames, fmt.Sprintf(`{"filename": %q, %v}`, f[0], f[1]))
+		}
+	}
+	var begin, end = "[", "]"
+	if hash {
+		begin, end = "{", "}"
+	}
+	return `{"frames": ` + begin + strings.Join(frames, ",") + end +
+		`, "meta": {"image": "sheet.png", "size": {"w": 64, "h": 32}}}`
+}
+
+func TestReadTexturePackerSheet(t *testing.T) {
+	var (
+		names = []string{"plain", "trimmed", "pivoted", "rotated"}
+		rects = []texturePackerRect{
+			{X: 0, Y: 0, W: 20, H: 10},
+			{X: 20, Y: 0, W: 10, H: 10},
+			{X: 30, Y: 0, W: 10, H: 20},
+			{X: 40, Y: 0, W: 10, H: 20},
+		}
+		offsets = map[string]twodee.Point{
+			"plain":   twodee.Pt(0, 0),
+			"trimmed": twodee.Pt(0.5, 0.5),
+			"pivoted": twodee.Pt(0, 1),
+			"rotated": twodee.Pt(0, 0),
+		}
+		rotated = map[string]bool{"rotated": true}
+	)
+	for _, hash := range []bool{false, true} {
+		var sheet, frames, meta, err = readTexturePackerSheet([]byte(spritesheetTestJSON(hash)), 10)
+		if err != nil {
+			t.Fatalf("Hash %v: %v", hash, err)
+		}
+		if !reflect.DeepEqual(sheet.Names, names) {
+			t.Errorf("Hash %v: got names %v, want %v", hash, sheet.Names, names)
+		}
+		for i, f := range frames {
+			if f.Frame != rects[i] || f.Rotated {
+				t.Errorf("Hash %v: frame %v is %+v rotated %v, want %+v unrotated", hash, f.Filename, f.Frame, f.Rotated, rects[i])
+			}
+		}
+		if !reflect.DeepEqual(sheet.Offsets, offsets) {
+			t.Errorf("Hash %v: got offsets %v, want %v", hash, sheet.Offsets, offsets)
+		}
+		if !reflect.DeepEqual(sheet.Rotated, rotated) {
+			t.Errorf("Hash %v: got rotated %v, want %v", hash, sheet.Rotated, rotated)
+		}
+		if !strings.Contains(string(meta), "sheet.png") {
+			t.Errorf("Hash %v: lost the sheet's meta, got %s", hash, meta)
+		}
+	}
+	if _, _, _, err := readTexturePackerSheet([]byte(`{"frames": 3}`), 10); err == nil {
+		t.Errorf("Expected an error for frames which are neither an array nor a hash")
+	}
+}
@@ -15,13 +15,21 @@
 package main
 
 type State struct {
-	ObjectCount int32
-	Exit        bool
+	ObjectCount  int32
+	PlayerSpeed  int32
+	PathDiagonal int32
+	LevelName    string
+	LevelSeed    int64
+	Exit         bool
 }
 
-func NewState() *State {
+func NewState(seed int64) *State {
 	return &State{
-		ObjectCount: 512,
-		Exit:        false,
+		ObjectCount:  512,
+		PlayerSpeed:  8,
+		PathDiagonal: 1,
+		LevelName:    "level2",
+		LevelSeed:    seed,
+		Exit:         false,
 	}
 }
@@ -0,0 +1,136 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	twodee "../../libs/twodee"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Swarm is a crowd of entities which all head for the same goal by sampling
+// a shared FlowField, so steering them costs the same however many there
+// are.  It steers by setting velocities, which a MovementSystem follows.
+type Swarm struct {
+	Members []EntityID
+	Field   *FlowField
+	Grid    *CollisionGrid
+
+	// Speed is how many world units a second members move.
+	Speed float32
+
+	hasGoal bool
+}
+
+func NewSwarm(grid *CollisionGrid, costs *CostGrid, opts PathOptions) *Swarm {
+	return &Swarm{
+		Field: NewFlowField(costs, opts),
+		Grid:  grid,
+	}
+}
+
+// Spawn destroys the members and replaces them with count new entities on
+// random passable cells.  Members have a transform and velocity, and are
+// left for the caller to give sprites or other components.
+func (s *Swarm) Spawn(w *World, count int, rng *rand.Rand) {
+	var (
+		costs = s.Field.Costs
+		open  []int32
+	)
+	s.Despawn(w)
+	for i, c := range costs.Costs {
+		if c >= 0 {
+			open = append(open, int32(i))
+		}
+	}
+	if len(open) == 0 {
+		return
+	}
+	for i := 0; i < count; i++ {
+		var (
+			cell = open[rng.Intn(len(open))]
+			pt   = s.Grid.GridToWorld(cell%costs.Width, cell/costs.Width)
+			id   = w.Create()
+		)
+		// Scatter members within their cell so they do not stack exactly.
+		pt.X += (rng.Float32() - 0.5) * s.Grid.TileWidth / 2
+		pt.Y += (rng.Float32() - 0.5) * s.Grid.TileHeight / 2
+		w.SetTransform(id, Transform{Pos: pt})
+		w.SetVelocity(id, Velocity{})
+		s.Members = append(s.Members, id)
+	}
+}
+
+// Despawn destroys every member.
+func (s *Swarm) Despawn(w *World) {
+	for _, id := range s.Members {
+		w.Destroy(id)
+	}
+	s.Members = s.Members[:0]
+}
+
+// SetOptions changes how the field moves between cells, forcing it to be
+// recomputed on the next SetGoal if they differ.
+func (s *Swarm) SetOptions(opts PathOptions) {
+	if s.Field.Options != opts {
+		s.Field.Options = opts
+		s.Field.Reset()
+	}
+}
+
+// SetGoal points the swarm at a world point.  The field is only recomputed
+// when the point moves into a different cell.  Until a goal which can be
+// reached is set, members stay put.
+func (s *Swarm) SetGoal(pt twodee.Point) bool {
+	var x, y = s.Grid.WorldToGrid(pt)
+	s.hasGoal = s.Field.SetGoal(x, y)
+	return s.hasGoal
+}
+
+// Update steers every member to move up to Speed * elapsed world units
+// toward the next cell of the field, landing on it if it is closer than
+// that.  Members which cannot reach the goal stop.
+func (s *Swarm) Update(w *World, elapsed time.Duration) {
+	var (
+		dt     = float32(elapsed.Seconds())
+		budget = s.Speed * dt
+	)
+	for _, id := range s.Members {
+		var v = &w.Velocities[id.Index()]
+		if !s.hasGoal || dt <= 0 {
+			*v = Velocity{}
+			continue
+		}
+		var (
+			pos      = w.Transforms[id.Index()].Pos
+			next, ok = s.Field.Sample(s.Grid, pos)
+		)
+		if !ok {
+			*v = Velocity{}
+			continue
+		}
+		var (
+			dx   = next.X - pos.X
+			dy   = next.Y - pos.Y
+			dist = float32(math.Sqrt(float64(dx*dx + dy*dy)))
+		)
+		if dist <= budget {
+			*v = Velocity{dx / dt, dy / dt}
+			continue
+		}
+		*v = Velocity{dx / dist * s.Speed, dy / dist * s.Speed}
+	}
+}
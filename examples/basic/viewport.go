@@ -0,0 +1,100 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	twodee "../../libs/twodee"
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+// Viewport shows the world through its own camera in part of the window.
+//
+// Renderers are tied to the camera they were created with, so a layer keeps
+// one render camera and calls Bind to point it at each viewport in turn.
+// The viewport's own camera covers just its rectangle, which keeps following,
+// zooming and ScreenToWorld independent of the other viewports.
+type Viewport struct {
+	Name string
+
+	// Screen is the viewport's rectangle in window pixels, measured from
+	// the top left like mouse events.
+	Screen twodee.Rectangle
+
+	Camera *twodee.Camera
+	Follow *CameraController
+}
+
+// NewViewport creates a viewport showing pxPerUnit window pixels per world
+// unit at a zoom of 1.
+func NewViewport(name string, screen twodee.Rectangle, pxPerUnit float32) (vp *Viewport, err error) {
+	var (
+		camera *twodee.Camera
+		w      = screen.Max.X - screen.Min.X
+		h      = screen.Max.Y - screen.Min.Y
+	)
+	if camera, err = twodee.NewCamera(twodee.Rect(0, 0, w/pxPerUnit, h/pxPerUnit), twodee.Rect(0, 0, w, h)); err != nil {
+		return
+	}
+	vp = &Viewport{
+		Name:   name,
+		Screen: screen,
+		Camera: camera,
+		Follow: NewCameraController(camera, w/pxPerUnit, h/pxPerUnit),
+	}
+	return
+}
+
+func (vp *Viewport) Contains(x, y float32) bool {
+	return x >= vp.Screen.Min.X && x < vp.Screen.Max.X &&
+		y >= vp.Screen.Min.Y && y < vp.Screen.Max.Y
+}
+
+// ScreenToWorld converts window coordinates to the world point this viewport
+// shows there.
+func (vp *Viewport) ScreenToWorld(x, y float32) twodee.Point {
+	return twodee.Pt(vp.Camera.ScreenToWorldCoords(x-vp.Screen.Min.X, y-vp.Screen.Min.Y))
+}
+
+// Bind limits drawing to the viewport and points camera at what it shows.
+// window is the size of the whole window.
+func (vp *Viewport) Bind(camera *twodee.Camera, window twodee.Rectangle) {
+	var (
+		x = int32(vp.Screen.Min.X)
+		y = int32(window.Max.Y - vp.Screen.Max.Y)
+		w = int32(vp.Screen.Max.X - vp.Screen.Min.X)
+		h = int32(vp.Screen.Max.Y - vp.Screen.Min.Y)
+	)
+	camera.SetWorldBounds(vp.Camera.WorldBounds)
+	gl.Viewport(x, y, w, h)
+	gl.Scissor(x, y, w, h)
+	gl.Enable(gl.SCISSOR_TEST)
+}
+
+// UnbindViewports restores drawing to the whole window.
+func UnbindViewports(window twodee.Rectangle) {
+	gl.Disable(gl.SCISSOR_TEST)
+	gl.Viewport(0, 0, int32(window.Max.X), int32(window.Max.Y))
+}
+
+// ViewportAt returns the viewport under a window point.  Later viewports are
+// drawn on top, so they win where viewports overlap.
+func ViewportAt(viewports []*Viewport, x, y float32) *Viewport {
+	for i := len(viewports) - 1; i >= 0; i-- {
+		if viewports[i].Contains(x, y) {
+			return viewports[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command gridexport writes an image of a TMX layer's collision grid for
+// debugging, without opening a window.
+//
+//	gridexport [-layer collision] [-seed 0] [-start x,y] [-goal x,y] [-out collision.png] map.tmx
+//
+// Maps are automapped first, like the game does, so the collision layer
+// generated by the rules is exported rather than the hand painted one.  Pass
+// the game's -seed to export the same level it played.
+// Every tile in the layer is a wall, drawn black.  Open cells are coloured by
+// connected component.  Components which cannot be reached are drawn striped
+// with red: those apart from the start's component, or apart from the largest
+// one if no start is given.  With both a start and a goal the path between
+// them is drawn in white.  Points are grid cells, with row 0 at the top.
+package main
+
+import (
+	twodee "../../libs/twodee"
+	tiled "../tiled"
+	"flag"
+	"fmt"
+	"github.com/kurrik/tmxgo"
+	"image"
+	"image/color"
+	"math"
+	"os"
+)
+
+var (
+	WallColor        = color.RGBA{0, 0, 0, 255}
+	UnreachableColor = color.RGBA{255, 0, 0, 255}
+	PathColor        = color.RGBA{255, 255, 255, 255}
+	StartColor       = color.RGBA{0, 255, 255, 255}
+	GoalColor        = color.RGBA{255, 0, 255, 255}
+)
+
+// Cell is an optional grid coordinate parsed from a flag.
+type Cell struct {
+	X, Y  int32
+	Given bool
+}
+
+func (c *Cell) String() string {
+	if !c.Given {
+		return ""
+	}
+	return fmt.Sprintf("%v,%v", c.X, c.Y)
+}
+
+func (c *Cell) Set(value string) (err error) {
+	if _, err = fmt.Sscanf(value, "%d,%d", &c.X, &c.Y); err != nil {
+		return fmt.Errorf("Expected x,y: %v", err)
+	}
+	c.Given = true
+	return
+}
+
+// LoadGrid loads a map the way the game does, automapping it with the
+// rules.txt beside it, and marks every cell of a layer which has a tile.
+func LoadGrid(path, layer string, seed int64) (grid *twodee.Grid, err error) {
+	var (
+		m     *tmxgo.Map
+		cells []bool
+	)
+	if _, m, err = tiled.LoadMap(path, seed); err != nil {
+		return
+	}
+	if cells, err = tiled.LayerCells(m, layer); err != nil {
+		return
+	}
+	grid = twodee.NewGrid(m.Width, m.Height)
+	for i, filled := range cells {
+		if filled {
+			grid.SetIndex(int32(i), true)
+		}
+	}
+	return
+}
+
+// Components labels the open cells of a grid by 4-connected region, counting
+// from 0.  Walls are labelled -1.  sizes holds the cell count of each label.
+func Components(grid *twodee.Grid) (labels []int32, sizes []int) {
+	var (
+		count = grid.Width * grid.Height
+		stack []int32
+	)
+	labels = make([]int32, count)
+	for i := range labels {
+		labels[i] = -1
+	}
+	for i := int32(0); i < count; i++ {
+		if labels[i] >= 0 || grid.GetIndex(i) {
+			continue
+		}
+		var label = int32(len(sizes))
+		sizes = append(sizes, 0)
+		labels[i] = label
+		stack = append(stack[:0], i)
+		for len(stack) > 0 {
+			var (
+				cell = stack[len(stack)-1]
+				x    = cell % grid.Width
+				y    = cell / grid.Width
+			)
+			stack = stack[:len(stack)-1]
+			sizes[label]++
+			for _, d := range [][2]int32{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+				var nx, ny = x + d[0], y + d[1]
+				if nx < 0 || ny < 0 || nx >= grid.Width || ny >= grid.Height {
+					continue
+				}
+				var n = ny*grid.Width + nx
+				if labels[n] < 0 && !grid.GetIndex(n) {
+					labels[n] = label
+					stack = append(stack, n)
+				}
+			}
+		}
+	}
+	return
+}
+
+// ComponentColor spreads labels around the hue circle so neighbouring
+// regions are easy to tell apart.  Hues near red are left out, since red
+// marks unreachable cells.
+func ComponentColor(label int32) color.RGBA {
+	var (
+		h    = 1 + math.Mod(float64(label)*0.618033988749895, 1)*4
+		x    = uint8(255 * (1 - math.Abs(math.Mod(h, 2)-1)))
+		dark = uint8(64)
+	)
+	switch int(h) {
+	case 0:
+		return color.RGBA{255, x, dark, 255}
+	case 1:
+		return color.RGBA{x, 255, dark, 255}
+	case 2:
+		return color.RGBA{dark, 255, x, 255}
+	case 3:
+		return color.RGBA{dark, x, 255, 255}
+	case 4:
+		return color.RGBA{x, dark, 255, 255}
+	}
+	return color.RGBA{255, dark, x, 255}
+}
+
+// Export draws the grid at scale pixels per cell.
+func Export(grid *twodee.Grid, labels []int32, reachable int32, path []twodee.Point, start, goal Cell, scale int) *image.RGBA {
+	var img = image.NewRGBA(image.Rect(0, 0, int(grid.Width)*scale, int(grid.Height)*scale))
+	fill := func(x, y int32, c color.RGBA) {
+		for py := 0; py < scale; py++ {
+			for px := 0; px < scale; px++ {
+				img.Set(int(x)*scale+px, int(y)*scale+py, c)
+			}
+		}
+	}
+	for y := int32(0); y < grid.Height; y++ {
+		for x := int32(0); x < grid.Width; x++ {
+			var label = labels[y*grid.Width+x]
+			switch {
+			case label < 0:
+				fill(x, y, WallColor)
+			case label != reachable:
+				// Stripe unreachable cells so they stand out whatever
+				// their component colour.
+				fill(x, y, ComponentColor(label))
+				for py := 0; py < scale; py++ {
+					for px := 0; px < scale; px++ {
+						if (int(x)*scale+px+int(y)*scale+py)%4 < 2 {
+							img.Set(int(x)*scale+px, int(y)*scale+py, UnreachableColor)
+						}
+					}
+				}
+			default:
+				fill(x, y, ComponentColor(label))
+			}
+		}
+	}
+	for _, pt := range path {
+		fill(int32(pt.X), int32(pt.Y), PathColor)
+	}
+	if start.Given {
+		fill(start.X, start.Y, StartColor)
+	}
+	if goal.Given {
+		fill(goal.X, goal.Y, GoalColor)
+	}
+	return img
+}
+
+func main() {
+	var (
+		start     Cell
+		goal      Cell
+		grid      *twodee.Grid
+		path      []twodee.Point
+		labels    []int32
+		sizes     []int
+		reachable int32 = -1
+		err       error
+		layer     = flag.String("layer", "collision", "Layer whose tiles are walls")
+		out       = flag.String("out", "collision.png", "Image to write")
+		scale     = flag.Int("scale", 4, "Pixels per grid cell")
+		seed      = flag.Int64("seed", 0, "Seed for random automapping rules")
+	)
+	flag.Var(&start, "start", "Start cell as x,y")
+	flag.Var(&goal, "goal", "Goal cell as x,y; needs -start")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %v [flags] map.tmx\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || *scale < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if grid, err = LoadGrid(flag.Arg(0), *layer, *seed); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not load %v: %v\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+	labels, sizes = Components(grid)
+	for _, c := range []Cell{start, goal} {
+		if c.Given && (c.X < 0 || c.Y < 0 || c.X >= grid.Width || c.Y >= grid.Height) {
+			fmt.Fprintf(os.Stderr, "Cell %v is outside the %vx%v grid\n", c.String(), grid.Width, grid.Height)
+			os.Exit(1)
+		}
+	}
+	if start.Given {
+		reachable = labels[start.Y*grid.Width+start.X]
+	} else {
+		for label, size := range sizes {
+			if reachable < 0 || size > sizes[reachable] {
+				reachable = int32(label)
+			}
+		}
+	}
+	var unreachable = 0
+	for label, size := range sizes {
+		if int32(label) != reachable {
+			unreachable += size
+		}
+	}
+	if reachable < 0 {
+		fmt.Printf("%v open regions, start is a wall\n", len(sizes))
+	} else {
+		fmt.Printf("%v open regions, %v cells reachable, %v unreachable\n", len(sizes), sizes[reachable], unreachable)
+	}
+	if start.Given && goal.Given {
+		if path, err = grid.GetPath(start.X, start.Y, goal.X, goal.Y); err != nil {
+			fmt.Printf("No path from %v to %v: %v\n", start.String(), goal.String(), err)
+		} else {
+			fmt.Printf("Path from %v to %v is %v cells\n", start.String(), goal.String(), len(path))
+		}
+	}
+	if err = twodee.WritePNG(*out, Export(grid, labels, reachable, path, start, goal, *scale)); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not write %v: %v\n", *out, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %v\n", *out)
+}
@@ -0,0 +1,361 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tiled
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	AutomapRegionsLayer   = "regions"
+	AutomapInputPrefix    = "input_"
+	AutomapWeightProperty = "weight"
+)
+
+// Output layers are named output_<layer> or output<N>_<layer>.  Layers which
+// share the same N form one alternative; see AutomapRule.
+var automapOutputPattern = regexp.MustCompile(`^output([0-9]*)_(.+)$`)
+
+type automapCell struct {
+	X int32
+	Y int32
+}
+
+// automapLayer holds one GID per rule cell for a single map layer.
+type automapLayer struct {
+	Name string
+	GIDs []uint32
+}
+
+// automapAlternative is one possible output of a rule.
+type automapAlternative struct {
+	Weight float64
+	Layers []automapLayer
+}
+
+// AutomapRule is one connected area of a rule file's regions layer.
+// Cells are relative to the top left of the area's bounding box.
+//
+// A rule with several alternatives picks one at random for every match,
+// in proportion to the "weight" property of the alternative's output layers.
+// Alternatives which are empty within the rule's area are never picked.
+type AutomapRule struct {
+	Source       string
+	Cells        []automapCell
+	Inputs       []automapLayer
+	Alternatives []automapAlternative
+}
+
+// Matches reports whether every input layer agrees with the map when the rule
+// is placed at (x, y).  An empty input cell requires an empty map cell.
+func (r *AutomapRule) Matches(layers map[string]*TileLayer, x, y int32) bool {
+	for _, input := range r.Inputs {
+		var layer = layers[input.Name]
+		for i, cell := range r.Cells {
+			var cx, cy = x + cell.X, y + cell.Y
+			if !layer.InBounds(cx, cy) {
+				return false
+			}
+			if layer.Get(cx, cy) != input.GIDs[i] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Choose picks the alternative to write for a single match.  The random
+// source is only consulted when there is more than one alternative, so
+// adding deterministic rules does not change the outcome of random ones.
+func (r *AutomapRule) Choose(rng *rand.Rand) *automapAlternative {
+	var total float64
+	switch len(r.Alternatives) {
+	case 0:
+		return nil
+	case 1:
+		return &r.Alternatives[0]
+	}
+	for _, alt := range r.Alternatives {
+		total += alt.Weight
+	}
+	var pick = rng.Float64() * total
+	for i := range r.Alternatives {
+		if pick < r.Alternatives[i].Weight {
+			return &r.Alternatives[i]
+		}
+		pick -= r.Alternatives[i].Weight
+	}
+	return &r.Alternatives[len(r.Alternatives)-1]
+}
+
+// Write copies every non-empty output cell of an alternative into the map
+// at (x, y).
+func (r *AutomapRule) Write(layers map[string]*TileLayer, alt *automapAlternative, x, y int32) {
+	for _, output := range alt.Layers {
+		var layer = layers[output.Name]
+		for i, cell := range r.Cells {
+			var cx, cy = x + cell.X, y + cell.Y
+			if output.GIDs[i] != 0 && layer.InBounds(cx, cy) {
+				layer.Set(cx, cy, output.GIDs[i])
+			}
+		}
+	}
+}
+
+// Automapper generates map layers from rule files, in the style of Tiled's
+// automapping.  Designers paint a "raw" layer and the rules produce the rest.
+// Random rules draw from Seed, so the same seed always yields the same level.
+type Automapper struct {
+	Rules []*AutomapRule
+	Seed  int64
+}
+
+// LoadAutomapper reads a rules.txt file.  Each line names a rule TMX file
+// relative to the rules.txt directory; blank lines and lines starting with
+// '#' are skipped.  Rules are applied in the order they are listed.
+func LoadAutomapper(path string, seed int64) (automapper *Automapper, err error) {
+	var (
+		data  []byte
+		dir   = filepath.Dir(path)
+		rules []*AutomapRule
+	)
+	if data, err = ioutil.ReadFile(path); err != nil {
+		return
+	}
+	automapper = &Automapper{Seed: seed}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if rules, err = LoadAutomapRules(filepath.Join(dir, line)); err != nil {
+			automapper = nil
+			return
+		}
+		automapper.Rules = append(automapper.Rules, rules...)
+	}
+	return
+}
+
+// LoadAutomapRules reads a single rule TMX file.  Every connected area of the
+// regions layer becomes its own rule, ordered top to bottom, left to right.
+func LoadAutomapRules(path string) (rules []*AutomapRule, err error) {
+	var (
+		doc     *TmxDocument
+		regions *TileLayer
+		inputs  []*TileLayer
+		outputs [][]*TileLayer
+		weights []float64
+		indices = map[string]int{}
+		layer   *TileLayer
+	)
+	if doc, err = LoadTmxDocument(path); err != nil {
+		return
+	}
+	for _, name := range doc.LayerNames() {
+		if layer, err = doc.Layer(name); err != nil {
+			return
+		}
+		var match = automapOutputPattern.FindStringSubmatch(name)
+		switch {
+		case name == AutomapRegionsLayer:
+			regions = layer
+		case strings.HasPrefix(name, AutomapInputPrefix):
+			layer.Name = strings.TrimPrefix(name, AutomapInputPrefix)
+			inputs = append(inputs, layer)
+		case match != nil:
+			layer.Name = match[2]
+			i, ok := indices[match[1]]
+			if !ok {
+				i = len(outputs)
+				indices[match[1]] = i
+				outputs = append(outputs, nil)
+				weights = append(weights, 1)
+			}
+			if value := doc.LayerProperty(name, AutomapWeightProperty); value != "" {
+				if weights[i], err = strconv.ParseFloat(value, 64); err != nil || weights[i] < 0 {
+					err = fmt.Errorf("Rule file %v: bad weight %q on layer %v", path, value, name)
+					return
+				}
+			}
+			outputs[i] = append(outputs[i], layer)
+		}
+	}
+	if regions == nil {
+		err = fmt.Errorf("Rule file %v has no %v layer", path, AutomapRegionsLayer)
+		return
+	}
+	if len(inputs) == 0 {
+		err = fmt.Errorf("Rule file %v has no %v layers", path, AutomapInputPrefix)
+		return
+	}
+	for _, area := range automapAreas(regions) {
+		var rule = &AutomapRule{Source: path}
+		var minx, miny = area[0].X, area[0].Y
+		for _, cell := range area {
+			if cell.X < minx {
+				minx = cell.X
+			}
+			if cell.Y < miny {
+				miny = cell.Y
+			}
+		}
+		for _, cell := range area {
+			rule.Cells = append(rule.Cells, automapCell{cell.X - minx, cell.Y - miny})
+		}
+		rule.Inputs = automapSample(inputs, area)
+		for i, layers := range outputs {
+			var alt = automapAlternative{
+				Weight: weights[i],
+				Layers: automapSample(layers, area),
+			}
+			if alt.Weight > 0 && !alt.Empty() {
+				rule.Alternatives = append(rule.Alternatives, alt)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return
+}
+
+// automapAreas returns the 4-connected areas of non-empty cells in a layer.
+func automapAreas(regions *TileLayer) (areas [][]automapCell) {
+	var seen = make([]bool, len(regions.GIDs))
+	for y := int32(0); y < regions.Height; y++ {
+		for x := int32(0); x < regions.Width; x++ {
+			if seen[y*regions.Width+x] || regions.Get(x, y) == 0 {
+				continue
+			}
+			var (
+				area  []automapCell
+				stack = []automapCell{{x, y}}
+			)
+			seen[y*regions.Width+x] = true
+			for len(stack) > 0 {
+				var cell = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				area = append(area, cell)
+				for _, n := range []automapCell{
+					{cell.X - 1, cell.Y},
+					{cell.X + 1, cell.Y},
+					{cell.X, cell.Y - 1},
+					{cell.X, cell.Y + 1},
+				} {
+					if !regions.InBounds(n.X, n.Y) {
+						continue
+					}
+					var i = n.Y*regions.Width + n.X
+					if seen[i] || regions.GIDs[i] == 0 {
+						continue
+					}
+					seen[i] = true
+					stack = append(stack, n)
+				}
+			}
+			areas = append(areas, area)
+		}
+	}
+	return
+}
+
+func (a *automapAlternative) Empty() bool {
+	for _, layer := range a.Layers {
+		for _, gid := range layer.GIDs {
+			if gid != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func automapSample(layers []*TileLayer, area []automapCell) (out []automapLayer) {
+	for _, layer := range layers {
+		var sample = automapLayer{
+			Name: layer.Name,
+			GIDs: make([]uint32, len(area)),
+		}
+		for i, cell := range area {
+			sample.GIDs[i] = layer.Get(cell.X, cell.Y)
+		}
+		out = append(out, sample)
+	}
+	return
+}
+
+// Apply runs every rule over the document and writes the generated layers
+// back into it.  Layers named by an output are cleared first, so they are
+// derived entirely from the layers the designer painted.  Each rule finds all
+// of its matches before writing, so a rule never feeds on its own output, but
+// later rules see the output of earlier ones.
+func (a *Automapper) Apply(doc *TmxDocument) (err error) {
+	var (
+		layers  = map[string]*TileLayer{}
+		outputs []string
+		layer   *TileLayer
+		rng     = rand.New(rand.NewSource(a.Seed))
+	)
+	for _, rule := range a.Rules {
+		for _, alt := range rule.Alternatives {
+			for _, output := range alt.Layers {
+				if _, ok := layers[output.Name]; !ok {
+					layers[output.Name] = NewTileLayer(output.Name, doc.Width, doc.Height)
+					outputs = append(outputs, output.Name)
+				}
+			}
+		}
+	}
+	for _, rule := range a.Rules {
+		for _, input := range rule.Inputs {
+			if _, ok := layers[input.Name]; ok {
+				continue
+			}
+			if layer, err = doc.Layer(input.Name); err != nil {
+				return
+			}
+			if layer == nil {
+				layer = NewTileLayer(input.Name, doc.Width, doc.Height)
+			}
+			layers[input.Name] = layer
+		}
+	}
+	for _, rule := range a.Rules {
+		var matches []automapCell
+		for y := int32(0); y < doc.Height; y++ {
+			for x := int32(0); x < doc.Width; x++ {
+				if rule.Matches(layers, x, y) {
+					matches = append(matches, automapCell{x, y})
+				}
+			}
+		}
+		for _, m := range matches {
+			if alt := rule.Choose(rng); alt != nil {
+				rule.Write(layers, alt, m.X, m.Y)
+			}
+		}
+	}
+	for _, name := range outputs {
+		if err = doc.SetLayer(layers[name]); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,155 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tiled
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testLayer is a tile layer for testTmx, with an optional weight property.
+type testLayer struct {
+	Name   string
+	Weight string
+	GIDs   []uint32
+}
+
+// testTmx returns a TMX document with CSV encoded layers.
+func testTmx(width, height int32, layers ...testLayer) string {
+	var out = fmt.Sprintf(`<map version="1.0" orientation="orthogonal" width="%v" height="%v" tilewidth="16" tileheight="16">`, width, height)
+	for _, layer := range layers {
+		var values = make([]string, len(layer.GIDs))
+		for i, gid := range layer.GIDs {
+			values[i] = fmt.Sprint(gid)
+		}
+		out += fmt.Sprintf(`<layer name="%v" width="%v" height="%v">`, layer.Name, width, height)
+		if layer.Weight != "" {
+			out += fmt.Sprintf(`<properties><property name="weight" value="%v"/></properties>`, layer.Weight)
+		}
+		out += fmt.Sprintf(`<data encoding="csv">%v</data></layer>`, strings.Join(values, ","))
+	}
+	return out + "</map>"
+}
+
+// testFill returns count copies of gid.
+func testFill(count int, gid uint32) (gids []uint32) {
+	gids = make([]uint32, count)
+	for i := range gids {
+		gids[i] = gid
+	}
+	return
+}
+
+// loadTestRules writes a rule file and loads it.
+func loadTestRules(t *testing.T, tmx string) (rules []*AutomapRule) {
+	var dir, err = ioutil.TempDir("", "automapper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var path = filepath.Join(dir, "rules.tmx")
+	if err = ioutil.WriteFile(path, []byte(tmx), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if rules, err = LoadAutomapRules(path); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// applyTestRules automaps a map whose raw layer is raw, and returns the
+// generated ground layer.
+func applyTestRules(t *testing.T, rules []*AutomapRule, seed int64, width, height int32, raw []uint32) []uint32 {
+	var doc, err = ParseTmxDocument([]byte(testTmx(width, height, testLayer{Name: "raw", GIDs: raw})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = (&Automapper{Rules: rules, Seed: seed}).Apply(doc); err != nil {
+		t.Fatal(err)
+	}
+	var ground *TileLayer
+	if ground, err = doc.Layer("ground"); err != nil || ground == nil {
+		t.Fatalf("No ground layer: %v", err)
+	}
+	return ground.GIDs
+}
+
+func TestAutomapperSeed(t *testing.T) {
+	var (
+		rules = loadTestRules(t, testTmx(1, 1,
+			testLayer{Name: "regions", GIDs: []uint32{1}},
+			testLayer{Name: "input_raw", GIDs: []uint32{1}},
+			testLayer{Name: "output1_ground", GIDs: []uint32{10}},
+			testLayer{Name: "output2_ground", GIDs: []uint32{11}},
+		))
+		raw   = testFill(64, 1)
+		first = applyTestRules(t, rules, 1, 8, 8, raw)
+	)
+	if again := applyTestRules(t, rules, 1, 8, 8, raw); !reflect.DeepEqual(first, again) {
+		t.Errorf("Seed 1 gave %v, then %v", first, again)
+	}
+	if other := applyTestRules(t, rules, 2, 8, 8, raw); reflect.DeepEqual(first, other) {
+		t.Errorf("Seeds 1 and 2 both gave %v", first)
+	}
+}
+
+func TestAutomapperWeights(t *testing.T) {
+	var rules = loadTestRules(t, testTmx(1, 1,
+		testLayer{Name: "regions", GIDs: []uint32{1}},
+		testLayer{Name: "input_raw", GIDs: []uint32{1}},
+		testLayer{Name: "output1_ground", Weight: "3", GIDs: []uint32{10}},
+		testLayer{Name: "output2_ground", GIDs: []uint32{11}},
+		testLayer{Name: "output3_ground", Weight: "0", GIDs: []uint32{12}},
+		testLayer{Name: "output4_ground", GIDs: []uint32{0}},
+	))
+	if len(rules) != 1 || len(rules[0].Alternatives) != 2 {
+		t.Fatalf("Expected one rule with the two non-empty weighted alternatives, got %v", rules)
+	}
+	var (
+		ground = applyTestRules(t, rules, 1, 40, 40, testFill(1600, 1))
+		counts = map[uint32]int{}
+	)
+	for _, gid := range ground {
+		counts[gid]++
+	}
+	if counts[10]+counts[11] != len(ground) {
+		t.Fatalf("Expected only tiles 10 and 11, got %v", counts)
+	}
+	if share := float64(counts[10]) / float64(len(ground)); share < 0.7 || share > 0.8 {
+		t.Errorf("Weight 3 of 4 was chosen for %.2f of cells", share)
+	}
+}
+
+// TestAutomapperEmptyInput checks that an empty input cell only matches an
+// empty map cell.
+func TestAutomapperEmptyInput(t *testing.T) {
+	var (
+		rules = loadTestRules(t, testTmx(2, 1,
+			testLayer{Name: "regions", GIDs: []uint32{1, 1}},
+			testLayer{Name: "input_raw", GIDs: []uint32{1, 0}},
+			testLayer{Name: "output_ground", GIDs: []uint32{5, 6}},
+		))
+		ground = applyTestRules(t, rules, 1, 5, 1, []uint32{1, 0, 1, 1, 0})
+		want   = []uint32{5, 6, 0, 5, 6}
+	)
+	if !reflect.DeepEqual(ground, want) {
+		t.Errorf("Got ground %v, want %v", ground, want)
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tiled reads, edits and automaps maps made with the Tiled editor.
+package tiled
+
+import (
+	"github.com/kurrik/tmxgo"
+	"os"
+	"path/filepath"
+)
+
+// RulesFile is the automapping rules file read from beside a map.
+const RulesFile = "rules.txt"
+
+// LoadMap reads the map at path, automapping it if its directory has a
+// rules.txt.  Random automapping rules are driven by seed, so the same seed
+// always produces the same map.
+func LoadMap(path string, seed int64) (doc *TmxDocument, m *tmxgo.Map, err error) {
+	var (
+		automapper *Automapper
+		rules      = filepath.Join(filepath.Dir(path), RulesFile)
+	)
+	if doc, err = LoadTmxDocument(path); err != nil {
+		return
+	}
+	if _, err = os.Stat(rules); err == nil {
+		if automapper, err = LoadAutomapper(rules, seed); err != nil {
+			return
+		}
+		if err = automapper.Apply(doc); err != nil {
+			return
+		}
+	} else if !os.IsNotExist(err) {
+		return
+	}
+	m, err = tmxgo.ParseMapString(doc.String())
+	return
+}
+
+// LayerCells reports which cells of layer have a tile, in the map's row
+// order with row 0 at the top.
+func LayerCells(m *tmxgo.Map, layer string) (cells []bool, err error) {
+	var tiles []*tmxgo.Tile
+	if tiles, err = m.TilesFromLayerName(layer); err != nil {
+		return
+	}
+	cells = make([]bool, len(tiles))
+	for i, t := range tiles {
+		cells[i] = t != nil
+	}
+	return
+}
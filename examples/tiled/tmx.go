@@ -0,0 +1,445 @@
+// Copyright 2014 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tiled
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// xmlNode is a generic XML element.  TMX documents are kept in this form so
+// that layers can be rewritten without losing anything tmxgo knows about.
+type xmlNode struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Text     string     `xml:",chardata"`
+	Children []*xmlNode `xml:",any"`
+}
+
+func (n *xmlNode) Attr(name string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func (n *xmlNode) SetAttr(name, value string) {
+	for i, a := range n.Attrs {
+		if a.Name.Local == name {
+			n.Attrs[i].Value = value
+			return
+		}
+	}
+	n.Attrs = append(n.Attrs, xml.Attr{Name: xml.Name{Local: name}, Value: value})
+}
+
+func (n *xmlNode) Child(name string) *xmlNode {
+	for _, c := range n.Children {
+		if c.XMLName.Local == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func (n *xmlNode) trim() {
+	n.Text = strings.TrimSpace(n.Text)
+	for _, c := range n.Children {
+		c.trim()
+	}
+}
+
+// TileLayer holds the raw global tile IDs of a single TMX layer.
+// A GID of zero means the cell is empty.
+type TileLayer struct {
+	Name   string
+	Width  int32
+	Height int32
+	GIDs   []uint32
+}
+
+func NewTileLayer(name string, width, height int32) *TileLayer {
+	return &TileLayer{
+		Name:   name,
+		Width:  width,
+		Height: height,
+		GIDs:   make([]uint32, width*height),
+	}
+}
+
+func (l *TileLayer) InBounds(x, y int32) bool {
+	return x >= 0 && y >= 0 && x < l.Width && y < l.Height
+}
+
+func (l *TileLayer) Get(x, y int32) uint32 {
+	return l.GIDs[y*l.Width+x]
+}
+
+func (l *TileLayer) Set(x, y int32, gid uint32) {
+	l.GIDs[y*l.Width+x] = gid
+}
+
+// TmxObject is an object from one of the map's object groups.  Positions and
+// sizes are in pixels, measured from the top left of the map.
+type TmxObject struct {
+	Group      string
+	Name       string
+	Type       string
+	X          float32
+	Y          float32
+	Width      float32
+	Height     float32
+	Properties map[string]string
+}
+
+// TmxDocument is an editable TMX map.  Layers may be read and replaced, and
+// String() produces a document which tmxgo.ParseMapString accepts.
+type TmxDocument struct {
+	Width  int32
+	Height int32
+	root   *xmlNode
+}
+
+func ParseTmxDocument(data []byte) (doc *TmxDocument, err error) {
+	var (
+		root   = &xmlNode{}
+		width  int64
+		height int64
+	)
+	if err = xml.Unmarshal(data, root); err != nil {
+		return
+	}
+	if root.XMLName.Local != "map" {
+		err = fmt.Errorf("Expected <map> root element, got <%v>", root.XMLName.Local)
+		return
+	}
+	root.trim()
+	if width, err = strconv.ParseInt(root.Attr("width"), 10, 32); err != nil {
+		return
+	}
+	if height, err = strconv.ParseInt(root.Attr("height"), 10, 32); err != nil {
+		return
+	}
+	doc = &TmxDocument{
+		Width:  int32(width),
+		Height: int32(height),
+		root:   root,
+	}
+	return
+}
+
+func LoadTmxDocument(path string) (doc *TmxDocument, err error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(path); err != nil {
+		return
+	}
+	if doc, err = ParseTmxDocument(data); err != nil {
+		err = fmt.Errorf("Could not parse %v: %v", path, err)
+	}
+	return
+}
+
+func (d *TmxDocument) layerNode(name string) *xmlNode {
+	for _, c := range d.root.Children {
+		if c.XMLName.Local == "layer" && c.Attr("name") == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// LayerNames returns the names of all tile layers in document order.
+func (d *TmxDocument) LayerNames() (names []string) {
+	for _, c := range d.root.Children {
+		if c.XMLName.Local == "layer" {
+			names = append(names, c.Attr("name"))
+		}
+	}
+	return
+}
+
+// Layer decodes the named tile layer, or returns nil if there is none.
+func (d *TmxDocument) Layer(name string) (layer *TileLayer, err error) {
+	var node = d.layerNode(name)
+	if node == nil {
+		return
+	}
+	layer = NewTileLayer(name, d.Width, d.Height)
+	if err = decodeLayerData(node.Child("data"), layer.GIDs); err != nil {
+		err = fmt.Errorf("Layer %v: %v", name, err)
+		layer = nil
+	}
+	return
+}
+
+// SetLayer encodes the layer into the document, replacing any layer with the
+// same name or appending a new one after the existing layers.
+func (d *TmxDocument) SetLayer(layer *TileLayer) (err error) {
+	var (
+		node = d.layerNode(layer.Name)
+		data *xmlNode
+	)
+	if layer.Width != d.Width || layer.Height != d.Height {
+		return fmt.Errorf("Layer %v is %vx%v, map is %vx%v", layer.Name, layer.Width, layer.Height, d.Width, d.Height)
+	}
+	if node == nil {
+		node = &xmlNode{XMLName: xml.Name{Local: "layer"}}
+		node.SetAttr("name", layer.Name)
+		node.SetAttr("width", strconv.Itoa(int(layer.Width)))
+		node.SetAttr("height", strconv.Itoa(int(layer.Height)))
+		d.insertLayerNode(node)
+	}
+	if data = node.Child("data"); data == nil {
+		data = &xmlNode{XMLName: xml.Name{Local: "data"}}
+		node.Children = append(node.Children, data)
+	}
+	return encodeLayerData(data, layer.GIDs)
+}
+
+func (d *TmxDocument) insertLayerNode(node *xmlNode) {
+	var last = -1
+	for i, c := range d.root.Children {
+		if c.XMLName.Local == "layer" {
+			last = i
+		}
+	}
+	if last == -1 {
+		d.root.Children = append(d.root.Children, node)
+		return
+	}
+	d.root.Children = append(d.root.Children, nil)
+	copy(d.root.Children[last+2:], d.root.Children[last+1:])
+	d.root.Children[last+1] = node
+}
+
+// LayerVisible reports whether the named layer is shown in the editor.
+func (d *TmxDocument) LayerVisible(layer string) bool {
+	var node = d.layerNode(layer)
+	return node != nil && node.Attr("visible") != "0"
+}
+
+// LayerOpacity returns the opacity of the named layer, from 0 to 1.
+func (d *TmxDocument) LayerOpacity(layer string) float32 {
+	var node = d.layerNode(layer)
+	if node == nil {
+		return 0
+	}
+	if opacity, err := strconv.ParseFloat(node.Attr("opacity"), 32); err == nil {
+		return float32(opacity)
+	}
+	return 1
+}
+
+// LayerProperty returns a custom property of the named layer, or "" if the
+// layer or property does not exist.
+func (d *TmxDocument) LayerProperty(layer, name string) string {
+	var node = d.layerNode(layer)
+	if node == nil {
+		return ""
+	}
+	return tmxProperties(node)[name]
+}
+
+// TileProperties returns the custom properties of every tile which has any,
+// keyed by global tile ID.
+func (d *TmxDocument) TileProperties() map[uint32]map[string]string {
+	var out = map[uint32]map[string]string{}
+	for _, tileset := range d.root.Children {
+		if tileset.XMLName.Local != "tileset" {
+			continue
+		}
+		firstgid, err := strconv.ParseUint(tileset.Attr("firstgid"), 10, 32)
+		if err != nil {
+			continue
+		}
+		for _, tile := range tileset.Children {
+			if tile.XMLName.Local != "tile" {
+				continue
+			}
+			id, err := strconv.ParseUint(tile.Attr("id"), 10, 32)
+			if err != nil {
+				continue
+			}
+			if props := tmxProperties(tile); len(props) > 0 {
+				out[uint32(firstgid+id)] = props
+			}
+		}
+	}
+	return out
+}
+
+// Objects returns the objects of every object group in document order.
+func (d *TmxDocument) Objects() (objects []*TmxObject, err error) {
+	for _, group := range d.root.Children {
+		if group.XMLName.Local != "objectgroup" {
+			continue
+		}
+		for _, node := range group.Children {
+			if node.XMLName.Local != "object" {
+				continue
+			}
+			var obj = &TmxObject{
+				Group:      group.Attr("name"),
+				Name:       node.Attr("name"),
+				Type:       node.Attr("type"),
+				Properties: tmxProperties(node),
+			}
+			for _, f := range []struct {
+				attr string
+				dest *float32
+			}{
+				{"x", &obj.X},
+				{"y", &obj.Y},
+				{"width", &obj.Width},
+				{"height", &obj.Height},
+			} {
+				var value = node.Attr(f.attr)
+				if value == "" {
+					continue
+				}
+				var v float64
+				if v, err = strconv.ParseFloat(value, 32); err != nil {
+					err = fmt.Errorf("Object %v: bad %v %q", obj.Name, f.attr, value)
+					return
+				}
+				*f.dest = float32(v)
+			}
+			objects = append(objects, obj)
+		}
+	}
+	return
+}
+
+func tmxProperties(node *xmlNode) map[string]string {
+	var props = map[string]string{}
+	if list := node.Child("properties"); list != nil {
+		for _, p := range list.Children {
+			if p.XMLName.Local == "property" {
+				props[p.Attr("name")] = p.Attr("value")
+			}
+		}
+	}
+	return props
+}
+
+func (d *TmxDocument) String() string {
+	var out []byte
+	out, _ = xml.MarshalIndent(d.root, "", " ")
+	return xml.Header + string(out)
+}
+
+func decodeLayerData(data *xmlNode, gids []uint32) (err error) {
+	var (
+		raw    []byte
+		reader io.ReadCloser
+	)
+	if data == nil {
+		return fmt.Errorf("Missing <data> element")
+	}
+	switch data.Attr("encoding") {
+	case "":
+		var i = 0
+		for _, c := range data.Children {
+			if c.XMLName.Local != "tile" || i >= len(gids) {
+				continue
+			}
+			var gid uint64
+			if gid, err = strconv.ParseUint(c.Attr("gid"), 10, 32); err != nil {
+				return
+			}
+			gids[i] = uint32(gid)
+			i++
+		}
+		return
+	case "csv":
+		var values = strings.Split(data.Text, ",")
+		if len(values) != len(gids) {
+			return fmt.Errorf("Expected %v CSV values, got %v", len(gids), len(values))
+		}
+		for i, v := range values {
+			var gid uint64
+			if gid, err = strconv.ParseUint(strings.TrimSpace(v), 10, 32); err != nil {
+				return
+			}
+			gids[i] = uint32(gid)
+		}
+		return
+	case "base64":
+		if raw, err = base64.StdEncoding.DecodeString(data.Text); err != nil {
+			return
+		}
+	default:
+		return fmt.Errorf("Unsupported encoding %v", data.Attr("encoding"))
+	}
+	switch data.Attr("compression") {
+	case "":
+	case "zlib":
+		if reader, err = zlib.NewReader(bytes.NewReader(raw)); err != nil {
+			return
+		}
+	case "gzip":
+		if reader, err = gzip.NewReader(bytes.NewReader(raw)); err != nil {
+			return
+		}
+	default:
+		return fmt.Errorf("Unsupported compression %v", data.Attr("compression"))
+	}
+	if reader != nil {
+		defer reader.Close()
+		if raw, err = ioutil.ReadAll(reader); err != nil {
+			return
+		}
+	}
+	if len(raw) != len(gids)*4 {
+		return fmt.Errorf("Expected %v bytes of tile data, got %v", len(gids)*4, len(raw))
+	}
+	for i := range gids {
+		gids[i] = binary.LittleEndian.Uint32(raw[i*4:])
+	}
+	return
+}
+
+func encodeLayerData(data *xmlNode, gids []uint32) (err error) {
+	var (
+		buf    bytes.Buffer
+		writer = zlib.NewWriter(&buf)
+		raw    = make([]byte, len(gids)*4)
+	)
+	for i, gid := range gids {
+		binary.LittleEndian.PutUint32(raw[i*4:], gid)
+	}
+	if _, err = writer.Write(raw); err != nil {
+		return
+	}
+	if err = writer.Close(); err != nil {
+		return
+	}
+	data.SetAttr("encoding", "base64")
+	data.SetAttr("compression", "zlib")
+	data.Children = nil
+	data.Text = base64.StdEncoding.EncodeToString(buf.Bytes())
+	return
+}